@@ -0,0 +1,188 @@
+package bridge
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrency limits number of messages processed in parallel using AIMD algorithm: limit grows while processing
+// latency stays flat and shrinks when latency rises or processor is not able to perform requests
+type AdaptiveConcurrency struct {
+	mu        sync.Mutex
+	min       int
+	max       int
+	tolerance float64
+	limit     float64
+	inflight  int
+	short     float64 // short-term moving average of latency
+	long      float64 // long-term moving average of latency, used as a baseline
+	waiters   []chan struct{}
+	onChange  func(int)
+	scaled    bool // limit is only adjusted using SetLimit
+}
+
+// latency moving average smoothing factors
+const (
+	adaptiveShortAlpha = 0.2
+	adaptiveLongAlpha  = 0.01
+	adaptiveDecrease   = 0.9
+)
+
+// NewAdaptiveConcurrency creates adaptive concurrency limit. Limit is decreased when short-term latency exceeds long-term
+// baseline more than tolerance times (eq. 1.5).
+func NewAdaptiveConcurrency(min, max int, tolerance float64) *AdaptiveConcurrency {
+	if min < 1 {
+		min = 1
+	}
+
+	if max < min {
+		max = min
+	}
+
+	if tolerance <= 1 {
+		tolerance = 1.5
+	}
+
+	return &AdaptiveConcurrency{
+		min:       min,
+		max:       max,
+		tolerance: tolerance,
+		limit:     float64(min),
+	}
+}
+
+// NewScaledConcurrency creates concurrency limit which is not adjusted according to latency, but only using SetLimit
+// (eq. by FPMAutoscaler)
+func NewScaledConcurrency(min, max int) *AdaptiveConcurrency {
+	a := NewAdaptiveConcurrency(min, max, 0)
+	a.scaled = true
+
+	return a
+}
+
+// Limit returns current concurrency limit
+func (a *AdaptiveConcurrency) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return int(a.limit)
+}
+
+// OnChange sets function called every time concurrency limit changes
+func (a *AdaptiveConcurrency) OnChange(fn func(limit int)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.onChange = fn
+}
+
+// Acquire waits until number of messages in processing is below concurrency limit
+func (a *AdaptiveConcurrency) Acquire(ctx context.Context) error {
+	a.mu.Lock()
+
+	if a.inflight < int(a.limit) && len(a.waiters) == 0 {
+		a.inflight++
+		a.mu.Unlock()
+
+		return nil
+	}
+
+	w := make(chan struct{})
+	a.waiters = append(a.waiters, w)
+	a.mu.Unlock()
+
+	select {
+	case <-w:
+		return nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		for i, x := range a.waiters {
+			if x == w {
+				a.waiters = append(a.waiters[:i], a.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+
+		// slot has been granted while context was cancelled
+		a.inflight--
+		a.wake()
+
+		return ctx.Err()
+	}
+}
+
+// Release slot and adjust concurrency limit according to processing latency and result
+func (a *AdaptiveConcurrency) Release(latency time.Duration, err error) {
+	a.mu.Lock()
+
+	saturated := a.inflight >= int(a.limit)
+	a.inflight--
+
+	before := int(a.limit)
+	l := latency.Seconds()
+
+	if a.long == 0 {
+		a.short, a.long = l, l
+	} else {
+		a.short += adaptiveShortAlpha * (l - a.short)
+		a.long += adaptiveLongAlpha * (l - a.long)
+	}
+
+	switch {
+	case a.scaled:
+	case err == ErrProcessorInternal || a.short > a.long*a.tolerance:
+		a.limit = math.Max(float64(a.min), a.limit*adaptiveDecrease)
+	case saturated:
+		// limit is only increased when it's actually reached, otherwise there is no evidence higher limit is sustainable
+		a.limit = math.Min(float64(a.max), a.limit+1/a.limit)
+	}
+
+	a.wake()
+
+	after, onChange := int(a.limit), a.onChange
+	a.mu.Unlock()
+
+	if after != before && onChange != nil {
+		onChange(after)
+	}
+}
+
+// SetLimit changes concurrency limit, limit is kept within min and max bounds
+func (a *AdaptiveConcurrency) SetLimit(limit int) {
+	a.mu.Lock()
+
+	before := int(a.limit)
+	a.limit = math.Max(float64(a.min), math.Min(float64(a.max), float64(limit)))
+	a.wake()
+
+	after, onChange := int(a.limit), a.onChange
+	a.mu.Unlock()
+
+	if after != before && onChange != nil {
+		onChange(after)
+	}
+}
+
+// Cancel releases slot without adjusting concurrency limit
+func (a *AdaptiveConcurrency) Cancel() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inflight--
+	a.wake()
+}
+
+// wake waiting consumers while there are free slots
+func (a *AdaptiveConcurrency) wake() {
+	for len(a.waiters) > 0 && a.inflight < int(a.limit) {
+		w := a.waiters[0]
+		a.waiters = a.waiters[1:]
+		a.inflight++
+
+		close(w)
+	}
+}
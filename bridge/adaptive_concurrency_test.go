@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// AdaptiveConcurrency should grow limit while latency stays flat and shrink it when latency rises
+func TestAdaptiveConcurrency_Latency(t *testing.T) {
+	a := NewAdaptiveConcurrency(1, 10, 1.5)
+
+	for i := 0; i < 100; i++ {
+		saturate(t, a)
+		a.Release(10*time.Millisecond, nil)
+	}
+
+	if a.Limit() != 10 {
+		t.Fatalf("Limit should grow to maximum while latency is flat, got %v", a.Limit())
+	}
+
+	for i := 0; i < 20; i++ {
+		saturate(t, a)
+		a.Release(100*time.Millisecond, nil)
+	}
+
+	if l := a.Limit(); l >= 10 {
+		t.Errorf("Limit should shrink when latency rises, got %v", l)
+	}
+}
+
+// AdaptiveConcurrency should shrink limit when processor is not able to perform requests
+func TestAdaptiveConcurrency_Errors(t *testing.T) {
+	a := NewAdaptiveConcurrency(2, 10, 1.5)
+	a.limit = 10
+
+	changes := 0
+	a.OnChange(func(int) {
+		changes++
+	})
+
+	for i := 0; i < 50; i++ {
+		a.Acquire(context.Background())
+		a.Release(10*time.Millisecond, ErrProcessorInternal)
+	}
+
+	if a.Limit() != 2 {
+		t.Errorf("Limit should shrink to minimum, got %v", a.Limit())
+	}
+
+	if changes == 0 {
+		t.Errorf("Limit changes should be reported")
+	}
+}
+
+// AdaptiveConcurrency should block when limit is reached
+func TestAdaptiveConcurrency_Wait(t *testing.T) {
+	a := NewAdaptiveConcurrency(1, 1, 1.5)
+	a.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := a.Acquire(ctx); err == nil {
+		t.Fatalf("Acquire should wait for a free slot")
+	}
+
+	a.Release(time.Millisecond, nil)
+
+	if err := a.Acquire(context.Background()); err != nil {
+		t.Errorf("Slot should be available after release, got %v", err)
+	}
+}
+
+// saturate acquires all slots but one, so next acquired slot reaches the limit
+func saturate(t *testing.T, a *AdaptiveConcurrency) {
+	a.mu.Lock()
+	a.inflight = int(a.limit) - 1
+	a.mu.Unlock()
+
+	if err := a.Acquire(context.Background()); err != nil {
+		t.Fatalf("Unable to acquire slot: %v", err)
+	}
+}
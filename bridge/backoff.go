@@ -1,36 +1,154 @@
 package bridge
 
-import "time"
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
 
-const maxBackOffTimeout = time.Minute
+// Jitter defines how backoff delay is randomized
+type Jitter int
 
-type backOff struct {
-	last  time.Time
-	delay time.Duration
+const (
+	// NoJitter uses exponential delay as is
+	NoJitter Jitter = iota
+	// FullJitter picks random delay between zero and exponential delay
+	FullJitter
+	// DecorrelatedJitter picks random delay between initial delay and previous delay multiplied by multiplier
+	DecorrelatedJitter
+)
+
+// ParseJitter parses jitter strategy name: "none", "full" or "decorrelated"
+func ParseJitter(name string) (Jitter, error) {
+	switch name {
+	case "", "none":
+		return NoJitter, nil
+	case "full":
+		return FullJitter, nil
+	case "decorrelated":
+		return DecorrelatedJitter, nil
+	}
+
+	return NoJitter, fmt.Errorf("unknown jitter strategy %q", name)
+}
+
+// BackOffOptions defines how backoff delay grows, zero values are replaced with defaults
+type BackOffOptions struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	ResetAfter time.Duration
+	Jitter     Jitter
+}
+
+// DefaultBackOff starts with one second delay and doubles it up to one minute, delay is reset after 10 seconds
+// without failures
+var DefaultBackOff = BackOffOptions{
+	Initial:    time.Second,
+	Multiplier: 2,
+	Max:        time.Minute,
+	ResetAfter: 10 * time.Second,
+}
+
+// BackOff calculates delays between retries
+type BackOff struct {
+	opts    BackOffOptions
+	mu      sync.Mutex
+	last    time.Time
+	delay   time.Duration
+	timeout time.Duration
+}
+
+// NewBackOff creates backoff with given options
+func NewBackOff(opts BackOffOptions) *BackOff {
+	if opts.Initial <= 0 {
+		opts.Initial = DefaultBackOff.Initial
+	}
+
+	if opts.Multiplier <= 0 {
+		opts.Multiplier = DefaultBackOff.Multiplier
+	}
+
+	if opts.Max <= 0 {
+		opts.Max = DefaultBackOff.Max
+	}
+
+	if opts.Max < opts.Initial {
+		opts.Max = opts.Initial
+	}
+
+	if opts.ResetAfter <= 0 {
+		opts.ResetAfter = DefaultBackOff.ResetAfter
+	}
+
+	return &BackOff{opts: opts}
 }
 
 // Timeout returns backoff delay
-func (b *backOff) Timeout() time.Duration {
-	// reset delay if last run took longer than 10 seconds (without backoff delay)
-	if time.Since(b.last)-b.delay > 10*time.Second {
+func (b *BackOff) Timeout() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// reset delay if last run took longer than reset period (without backoff delay)
+	if time.Since(b.last)-b.timeout > b.opts.ResetAfter {
 		b.delay = 0
 	}
 
 	b.last = time.Now()
-	b.delay = b.increase(b.delay)
 
-	return b.delay
+	switch b.opts.Jitter {
+	case FullJitter:
+		b.delay = b.increase(b.delay)
+		b.timeout = randomDuration(0, b.delay)
+	case DecorrelatedJitter:
+		// next delay is based on previous randomized delay
+		b.delay = randomDuration(b.opts.Initial, b.increase(b.delay))
+		b.timeout = b.delay
+	default:
+		b.delay = b.increase(b.delay)
+		b.timeout = b.delay
+	}
+
+	return b.timeout
+}
+
+// Delay returns backoff delay after given number of retries without tracking any state, so it can be used for many
+// independent retries, eq. individual messages
+func (b *BackOff) Delay(retries int) time.Duration {
+	d := b.opts.Initial
+	for i := 0; i < retries && d < b.opts.Max; i++ {
+		d = b.increase(d)
+	}
+
+	switch b.opts.Jitter {
+	case FullJitter:
+		return randomDuration(0, d)
+	case DecorrelatedJitter:
+		return randomDuration(b.opts.Initial, d)
+	}
+
+	return d
 }
 
 // increase backoff delay
-func (b *backOff) increase(d time.Duration) time.Duration {
+func (b *BackOff) increase(d time.Duration) time.Duration {
 	if d <= 0 {
-		return time.Second
+		return b.opts.Initial
 	}
 
-	if d*2 > maxBackOffTimeout {
-		return maxBackOffTimeout
+	if n := time.Duration(float64(d) * b.opts.Multiplier); n < b.opts.Max {
+		return n
+	}
+
+	return b.opts.Max
+}
+
+// randomDuration returns random duration in range [min, max]
+func randomDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
 	}
 
-	return d * 2
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
 }
@@ -19,7 +19,11 @@ func TestBackOffTimeout(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			b := &backOff{delay: test.delay, last: test.last}
+			b := NewBackOff(DefaultBackOff)
+			b.delay = test.delay
+			b.timeout = test.delay
+			b.last = test.last
+
 			d := b.Timeout()
 
 			if d != test.timeout {
@@ -28,3 +32,60 @@ func TestBackOffTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestBackOffTimeout_Options(t *testing.T) {
+	b := NewBackOff(BackOffOptions{Initial: 100 * time.Millisecond, Multiplier: 3, Max: time.Second})
+
+	for _, want := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second} {
+		if d := b.Timeout(); d != want {
+			t.Errorf("BackOff timeout does not match expected value: want %v, got %v", want, d)
+		}
+	}
+}
+
+func TestBackOffDelay(t *testing.T) {
+	b := NewBackOff(BackOffOptions{Initial: 100 * time.Millisecond, Multiplier: 3, Max: time.Second})
+
+	for retries, want := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second} {
+		if d := b.Delay(retries); d != want {
+			t.Errorf("BackOff delay after %v retries does not match expected value: want %v, got %v", retries, want, d)
+		}
+	}
+
+	// delay does not depend on previous calls
+	if d := b.Delay(0); d != 100*time.Millisecond {
+		t.Errorf("BackOff delay should not be tracked between calls, got %v", d)
+	}
+}
+
+func TestBackOffTimeout_Jitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter Jitter
+		min    time.Duration
+	}{
+		{name: "full", jitter: FullJitter, min: 0},
+		{name: "decorrelated", jitter: DecorrelatedJitter, min: time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBackOff(BackOffOptions{Initial: time.Second, Max: 10 * time.Second, Jitter: test.jitter})
+
+			for i := 0; i < 100; i++ {
+				if d := b.Timeout(); d < test.min || d > 10*time.Second {
+					t.Fatalf("BackOff timeout is out of range: %v", d)
+				}
+			}
+		})
+	}
+}
+
+func TestParseJitter(t *testing.T) {
+	if j, err := ParseJitter("decorrelated"); err != nil || j != DecorrelatedJitter {
+		t.Errorf("Unable to parse jitter strategy: %v", err)
+	}
+
+	if _, err := ParseJitter("foo"); err == nil {
+		t.Errorf("Unknown jitter strategy should cause an error")
+	}
+}
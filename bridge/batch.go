@@ -0,0 +1,286 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Batch configures queue consumer to process multiple messages in a single processor request
+type Batch struct {
+	// Size is maximum number of messages in a batch
+	Size int
+	// Timeout is maximum time to wait for batch to fill up since first message has been received
+	Timeout time.Duration
+	// Format of the batch body: "json" (JSON array of messages) or "ndjson" (one JSON message per line)
+	Format string
+}
+
+type batchItem struct {
+	Headers      map[string]string `json:"headers"`
+	Body         string            `json:"body"`
+	BodyEncoding string            `json:"body_encoding"`
+}
+
+type responseKey struct{}
+
+// withResponse attaches writer to the context, processor writes response body into this writer
+func withResponse(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, responseKey{}, w)
+}
+
+// responseFromContext returns writer for response body attached to the context
+func responseFromContext(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(responseKey{}).(io.Writer)
+	return w
+}
+
+// Consume messages in batches. Messages are accumulated until batch is full or batch timeout is reached.
+func (c *AMQPConsumer) consumeBatches(ctx context.Context, queue Queue, dv <-chan amqp.Delivery) error {
+	eg, ctx := errgroup.WithContext(ctx)
+
+	sem := make(chan struct{}, queue.Parallelism)
+
+	var batch []amqp.Delivery
+	var timeout <-chan time.Time
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		b := batch
+		batch = nil
+		timeout = nil
+
+		sem <- struct{}{}
+
+		eg.Go(func() error {
+			defer func() {
+				<-sem
+			}()
+
+			return c.processBatch(ctx, queue, b)
+		})
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-timeout:
+			flush()
+		case d, ok := <-dv:
+			// delivery channel is closed when gate closes or queue is drained, messages received so far are processed
+			if !ok {
+				flush()
+				break loop
+			}
+
+			batch = append(batch, d)
+
+			if len(batch) == 1 {
+				timeout = time.After(queue.Batch.Timeout)
+			}
+
+			if len(batch) >= queue.Batch.Size {
+				flush()
+			}
+		}
+	}
+
+	return eg.Wait()
+}
+
+// Process batch of messages. Processor may respond with a JSON array of results for every message: "ack" (or true),
+// "reject" (or false) and "requeue", otherwise all messages are settled according to processor error.
+func (c *AMQPConsumer) processBatch(ctx context.Context, queue Queue, batch []amqp.Delivery) error {
+	logctx := map[string]interface{}{
+		"batch_size":   len(batch),
+		"delivery_tag": batch[len(batch)-1].DeliveryTag,
+	}
+
+	c.log.Debug("Processing batch", logctx)
+
+	body, contentType, err := encodeBatch(batch, queue.Headers, queue.Batch.Format)
+	if err != nil {
+		return err
+	}
+
+	// messages are left unacked when consumer is stopping, so they are returned to the queue
+	for _, d := range batch {
+		if err := queue.throttle(ctx, d); err != nil {
+			return nil
+		}
+	}
+
+	if err := queue.acquire(ctx); err != nil {
+		return nil
+	}
+
+	h := map[string]string{
+		"CONTENT_TYPE": contentType,
+		"BATCH_SIZE":   fmt.Sprint(len(batch)),
+	}
+
+	resp := &bytes.Buffer{}
+
+	start := time.Now()
+	err = queue.Processor(withResponse(ctx, resp), h, body)
+	queue.release(time.Since(start), err)
+
+	switch err {
+	case nil: // 2xx
+		results := decodeBatchResults(resp.Bytes(), len(batch))
+		if results == nil {
+			c.log.Debug("Batch successfully processed", logctx)
+			return ackBatch(batch, queue.Parallelism == 1)
+		}
+
+		c.log.Debug("Batch processed with individual results", logctx)
+
+		var requeue bool
+		for i, d := range batch {
+			switch results[i] {
+			case "ack":
+				err = d.Ack(false)
+			case "reject":
+				queue.fail(1)
+				err = d.Reject(false)
+			default:
+				queue.fail(1)
+				requeue = true
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if requeue {
+			wait(ctx, queue.requeueDelay(redeliveries(batch...)))
+		}
+
+		for i, d := range batch {
+			if results[i] != "requeue" {
+				continue
+			}
+
+			if err := d.Reject(true); err != nil {
+				return err
+			}
+		}
+	case ErrProcessingError: // 4xx error
+		c.log.Debug(fmt.Sprintf("Batch processed with error: %v", err), logctx)
+
+		queue.fail(len(batch))
+
+		for _, d := range batch {
+			if err := d.Reject(false); err != nil {
+				return err
+			}
+		}
+	default:
+		t := queue.requeueDelay(redeliveries(batch...))
+		c.log.Error(fmt.Sprintf("Batch processing failed: %v. Waiting %v before putting messages back to the queue.", err, t), logctx)
+
+		queue.fail(len(batch))
+
+		// wait a bit before putting messages back to the queue
+		wait(ctx, t)
+
+		for _, d := range batch {
+			if err := d.Reject(true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ackBatch acknowledges all messages in the batch. Single multiple-ack is only safe when batches are processed one by
+// one, otherwise it would acknowledge messages of other batches still being processed.
+func ackBatch(batch []amqp.Delivery, multiple bool) error {
+	if multiple {
+		return batch[len(batch)-1].Ack(true)
+	}
+
+	for _, d := range batch {
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeBatch encodes batch of messages as JSON array or newline-delimited JSON
+func encodeBatch(batch []amqp.Delivery, m *HeaderMapping, format string) ([]byte, string, error) {
+	items := make([]batchItem, len(batch))
+
+	for i, d := range batch {
+		items[i].Headers = headers(d, m)
+
+		if isText(d.ContentType) && utf8.Valid(d.Body) {
+			items[i].Body = string(d.Body)
+			items[i].BodyEncoding = "text"
+		} else {
+			items[i].Body = base64.StdEncoding.EncodeToString(d.Body)
+			items[i].BodyEncoding = "base64"
+		}
+	}
+
+	switch format {
+	case "", "json":
+		data, err := json.Marshal(items)
+		return data, "application/json", err
+	case "ndjson":
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+
+		for _, item := range items {
+			if err := enc.Encode(item); err != nil {
+				return nil, "", err
+			}
+		}
+
+		return buf.Bytes(), "application/x-ndjson", nil
+	default:
+		return nil, "", fmt.Errorf("unknown batch format %q", format)
+	}
+}
+
+// decodeBatchResults parses list of results for every message in the batch, nil is returned if response does not contain
+// valid list of results
+func decodeBatchResults(data []byte, size int) []string {
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) != size {
+		return nil
+	}
+
+	results := make([]string, size)
+
+	for i, r := range raw {
+		switch r {
+		case true, "ack":
+			results[i] = "ack"
+		case false, "reject":
+			results[i] = "reject"
+		case "requeue":
+			results[i] = "requeue"
+		default:
+			return nil
+		}
+	}
+
+	return results
+}
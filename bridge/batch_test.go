@@ -0,0 +1,131 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeBatch(t *testing.T) {
+	batch := []amqp.Delivery{
+		{MessageId: "1", ContentType: "application/json", Body: []byte(`{"foo":"bar"}`)},
+		{MessageId: "2", ContentType: "application/octet-stream", Body: []byte{0, 1, 2}},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		data, contentType, err := encodeBatch(batch, nil, "json")
+		if err != nil {
+			t.Fatalf("Unable to encode batch: %v", err)
+		}
+
+		if contentType != "application/json" {
+			t.Errorf("Content type does not match: want %v, got %v", "application/json", contentType)
+		}
+
+		var items []batchItem
+		if err := json.Unmarshal(data, &items); err != nil {
+			t.Fatalf("Batch is not a valid JSON array: %v", err)
+		}
+
+		if len(items) != 2 {
+			t.Fatalf("Batch should contain 2 items, got %v", len(items))
+		}
+
+		if items[0].Headers["MESSAGE_ID"] != "1" || items[0].Body != `{"foo":"bar"}` || items[0].BodyEncoding != "text" {
+			t.Errorf("First item does not match: %+v", items[0])
+		}
+
+		if items[1].Headers["MESSAGE_ID"] != "2" || items[1].Body != "AAEC" || items[1].BodyEncoding != "base64" {
+			t.Errorf("Second item does not match: %+v", items[1])
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		data, contentType, err := encodeBatch(batch, nil, "ndjson")
+		if err != nil {
+			t.Fatalf("Unable to encode batch: %v", err)
+		}
+
+		if contentType != "application/x-ndjson" {
+			t.Errorf("Content type does not match: want %v, got %v", "application/x-ndjson", contentType)
+		}
+
+		lines := 0
+		s := bufio.NewScanner(bytes.NewReader(data))
+
+		for s.Scan() {
+			var item batchItem
+			if err := json.Unmarshal(s.Bytes(), &item); err != nil {
+				t.Fatalf("Line %v is not a valid JSON: %v", lines+1, err)
+			}
+
+			lines++
+		}
+
+		if lines != 2 {
+			t.Errorf("Batch should contain 2 lines, got %v", lines)
+		}
+	})
+}
+
+func TestDecodeBatchResults(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{name: "empty", data: "", want: nil},
+		{name: "not a list", data: `{"status":"ok"}`, want: nil},
+		{name: "size mismatch", data: `["ack"]`, want: nil},
+		{name: "unknown result", data: `["ack","foo"]`, want: nil},
+		{name: "strings", data: `["ack","requeue"]`, want: []string{"ack", "requeue"}},
+		{name: "booleans", data: `[true,false]`, want: []string{"ack", "reject"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := decodeBatchResults([]byte(test.data), 2); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Batch results do not match: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// partial batch should be processed when delivery channel is closed, eq. once queue is drained
+func TestAMQPConsumer_ConsumeBatchesClosed(t *testing.T) {
+	ack := &acknowledger{}
+	var sizes []string
+
+	queue := Queue{
+		Name:        "test",
+		Parallelism: 2,
+		Batch:       &Batch{Size: 10, Timeout: time.Minute},
+		Processor: func(c context.Context, h map[string]string, b []byte) error {
+			sizes = append(sizes, h["BATCH_SIZE"])
+			return nil
+		},
+	}
+
+	dv := make(chan amqp.Delivery, 2)
+	dv <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}
+	dv <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 2}
+	close(dv)
+
+	c := &AMQPConsumer{log: &nilLogger{}}
+
+	if err := c.consumeBatches(context.Background(), queue, dv); err != nil {
+		t.Fatalf("Unable to consume batches: %v", err)
+	}
+
+	if !reflect.DeepEqual(sizes, []string{"2"}) {
+		t.Errorf("Partial batch should be processed once, got batches of size %v", sizes)
+	}
+
+	if !reflect.DeepEqual(ack.settled, []string{"ack 1", "ack 2"}) {
+		t.Errorf("Messages of partial batch should be acked, got %v", ack.settled)
+	}
+}
@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/streadway/amqp"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	fcgiVersion         = 1
+	fcgiGetValues       = 9
+	fcgiGetValuesResult = 10
+)
+
+// CheckQueues verifies AMQP server is reachable and all queues, including parking queues, exist
+func CheckQueues(url string, queues []Queue) error {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return fmt.Errorf("unable to connect to AMQP server: %v", err)
+	}
+
+	defer conn.Close()
+
+	for _, q := range queues {
+		if err := checkQueue(conn, q.Name); err != nil {
+			return err
+		}
+
+		if q.ParkingQueue == "" {
+			continue
+		}
+
+		if err := checkQueue(conn, q.ParkingQueue); err != nil {
+			return fmt.Errorf("parking queue for %v: %v", q.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkQueue verifies queue exists, passive declare closes the channel when queue does not exist, so every queue is
+// checked in its own channel
+func checkQueue(conn *amqp.Connection, name string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("unable to open AMQP channel: %v", err)
+	}
+
+	defer ch.Close()
+
+	if _, err := ch.QueueInspect(name); err != nil {
+		return fmt.Errorf("unable to find queue %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// CheckFastCGI verifies FastCGI server answers management requests
+func CheckFastCGI(network, addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to FastCGI server: %v", err)
+	}
+
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	// FCGI_GET_VALUES record asking for FCGI_MAX_CONNS variable
+	name := "FCGI_MAX_CONNS"
+	content := append([]byte{byte(len(name)), 0}, name...)
+
+	req := []byte{fcgiVersion, fcgiGetValues, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[4:6], uint16(len(content)))
+
+	if _, err := conn.Write(append(req, content...)); err != nil {
+		return fmt.Errorf("unable to send request to FastCGI server: %v", err)
+	}
+
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("FastCGI server did not answer: %v", err)
+	}
+
+	if resp[0] != fcgiVersion || resp[1] != fcgiGetValuesResult {
+		return fmt.Errorf("unexpected response from FastCGI server: version %v, type %v", resp[0], resp[1])
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"github.com/streadway/amqp"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckQueues(t *testing.T) {
+	url := os.Getenv("TEST_AMQP_URL")
+	if url == "" {
+		t.Skip("This test requires AMQP server, use environment variable TEST_AMQP_URL to set AMQP URL")
+	}
+
+	if err := CheckQueues(url, []Queue{{Name: "amqp-cgi-bridge-missing-queue"}}); err == nil {
+		t.Errorf("Missing queue should cause an error")
+	}
+
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatalf("Unable to connect to AMQP server: %v", err)
+	}
+
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Unable to open AMQP channel: %v", err)
+	}
+
+	defer ch.Close()
+
+	queue, err := ch.QueueDeclare("", false, true, false, false, amqp.Table{})
+	if err != nil {
+		t.Fatalf("Unable to create test queue: %v", err)
+	}
+
+	if err := CheckQueues(url, []Queue{{Name: queue.Name, ParkingQueue: "amqp-cgi-bridge-missing-queue"}}); err == nil {
+		t.Errorf("Missing parking queue should cause an error")
+	}
+}
+
+func TestCheckFastCGI(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to start listener: %v", err)
+	}
+
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		// read request header and content, respond with empty FCGI_GET_VALUES_RESULT
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint16(req[4:6]))+int64(req[6]))
+		conn.Write([]byte{1, 10, 0, 0, 0, 0, 0, 0})
+	}()
+
+	if err := CheckFastCGI("tcp", ln.Addr().String(), time.Second); err != nil {
+		t.Errorf("FastCGI check should pass: %v", err)
+	}
+}
+
+func TestCheckFastCGI_NoAnswer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to start listener: %v", err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	defer ln.Close()
+
+	if err := CheckFastCGI("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Errorf("FastCGI check should fail when server closes connection without answer")
+	}
+}
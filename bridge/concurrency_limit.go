@@ -0,0 +1,130 @@
+package bridge
+
+import (
+	"context"
+	"sync"
+)
+
+// ConcurrencyLimit limits number of messages processed at the same time by multiple queue consumers. When limit is
+// reached, free slots are distributed between waiting consumers using weighted fair queueing, so a busy queue can not
+// starve the others.
+type ConcurrencyLimit struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting int
+	vtime   float64
+	queues  map[string]*fairQueue
+}
+
+// fairQueue holds consumers of an individual queue waiting for a free slot
+type fairQueue struct {
+	weight  float64
+	vtime   float64
+	waiters []chan struct{}
+}
+
+// NewConcurrencyLimit creates concurrency limit with given number of slots
+func NewConcurrencyLimit(limit int) *ConcurrencyLimit {
+	return &ConcurrencyLimit{
+		limit:  limit,
+		queues: make(map[string]*fairQueue),
+	}
+}
+
+// Acquire waits for a free slot, queue with higher weight gets proportionally more slots than other waiting queues
+func (l *ConcurrencyLimit) Acquire(ctx context.Context, queue string, weight int) error {
+	l.mu.Lock()
+
+	q, ok := l.queues[queue]
+	if !ok {
+		q = &fairQueue{}
+		l.queues[queue] = q
+	}
+
+	q.weight = float64(weight)
+	if q.weight <= 0 {
+		q.weight = 1
+	}
+
+	if l.active < l.limit && l.waiting == 0 {
+		l.active++
+		l.grant(q)
+		l.mu.Unlock()
+
+		return nil
+	}
+
+	// queue which was idle should not get advantage for the time it was not using its share
+	if len(q.waiters) == 0 && q.vtime < l.vtime {
+		q.vtime = l.vtime
+	}
+
+	w := make(chan struct{})
+	q.waiters = append(q.waiters, w)
+	l.waiting++
+	l.mu.Unlock()
+
+	select {
+	case <-w:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		for i, x := range q.waiters {
+			if x == w {
+				q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+				l.waiting--
+
+				return ctx.Err()
+			}
+		}
+
+		// slot has been granted while context was cancelled
+		l.release()
+
+		return ctx.Err()
+	}
+}
+
+// Release slot acquired by Acquire
+func (l *ConcurrencyLimit) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release()
+}
+
+// release passes slot to the waiting queue with the lowest virtual time, or frees it if nobody is waiting
+func (l *ConcurrencyLimit) release() {
+	var next *fairQueue
+
+	for _, q := range l.queues {
+		if len(q.waiters) > 0 && (next == nil || q.vtime < next.vtime) {
+			next = q
+		}
+	}
+
+	if next == nil {
+		l.active--
+		return
+	}
+
+	w := next.waiters[0]
+	next.waiters = next.waiters[1:]
+	l.waiting--
+
+	l.grant(next)
+	close(w)
+}
+
+// grant slot to the queue and advance its virtual time inversely proportional to its weight
+func (l *ConcurrencyLimit) grant(q *fairQueue) {
+	if q.vtime < l.vtime {
+		q.vtime = l.vtime
+	}
+
+	l.vtime = q.vtime
+	q.vtime += 1 / q.weight
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// ConcurrencyLimit should distribute free slots between waiting queues according to their weights
+func TestConcurrencyLimit_Fairness(t *testing.T) {
+	l := NewConcurrencyLimit(1)
+
+	if err := l.Acquire(context.Background(), "init", 1); err != nil {
+		t.Fatalf("Unable to acquire slot: %v", err)
+	}
+
+	granted := make(chan string)
+
+	for i := 0; i < 6; i++ {
+		for _, q := range []struct {
+			name   string
+			weight int
+		}{{"busy", 3}, {"other", 1}} {
+			go func(name string, weight int) {
+				l.Acquire(context.Background(), name, weight)
+				granted <- name
+			}(q.name, q.weight)
+		}
+	}
+
+	// wait for all consumers to start waiting
+	for i := 0; ; i++ {
+		l.mu.Lock()
+		waiting := l.waiting
+		l.mu.Unlock()
+
+		if waiting == 12 {
+			break
+		}
+
+		if i > 100 {
+			t.Fatalf("Consumers are not waiting for a slot")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	counts := map[string]int{}
+
+	for i := 0; i < 12; i++ {
+		l.Release()
+
+		name := <-granted
+		if i < 8 {
+			counts[name]++
+		}
+	}
+
+	if counts["busy"] != 6 || counts["other"] != 2 {
+		t.Errorf("Slots should be distributed according to weights, got %v", counts)
+	}
+}
+
+// ConcurrencyLimit should stop waiting when context is cancelled
+func TestConcurrencyLimit_Cancel(t *testing.T) {
+	l := NewConcurrencyLimit(1)
+	l.Acquire(context.Background(), "foo", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Acquire(ctx, "foo", 1); err == nil {
+		t.Fatalf("Acquire should fail when context is cancelled")
+	}
+
+	l.Release()
+
+	if err := l.Acquire(context.Background(), "foo", 1); err != nil {
+		t.Errorf("Slot should be available after release, got %v", err)
+	}
+}
@@ -2,11 +2,13 @@ package bridge
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
 	"golang.org/x/sync/errgroup"
-	"strings"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,28 +19,53 @@ type Queue struct {
 	Prefetch       int
 	Parallelism    int
 	FailureTimeout time.Duration
+	Requeue        *BackOff
+	ParkingQueue   string
+	PartitionKey   PartitionKey
+	Batch          *Batch
+	Limit          *ConcurrencyLimit
+	Adaptive       *AdaptiveConcurrency
+	RateLimit      *RateLimit
+	Gates          []Gate
+	Drain          *Drain
+	Weight         int
+	Headers        *HeaderMapping
 	Processor      Processor
 }
 
+// AMQPConsumerOptions defines when AMQP consumer gives up re-connecting to AMQP server (zero values mean no limit) and
+// delays between re-connects and consumer re-starts
+type AMQPConsumerOptions struct {
+	MaxReconnectAttempts int
+	MaxDowntime          time.Duration
+	Reconnect            BackOffOptions
+	Restart              BackOffOptions
+}
+
 type AMQPConsumer struct {
 	url    string
 	queues []Queue
+	opts   AMQPConsumerOptions
 	log    logger
 	wg     sync.WaitGroup
 	ctx    context.Context
 	cancel func()
+	done   chan struct{}
+	err    error
 }
 
 // NewAMQPConsumer constructs AMQP consumer and starts message processing routine
-func NewAMQPConsumer(ctx context.Context, url string, queues []Queue, log logger) *AMQPConsumer {
+func NewAMQPConsumer(ctx context.Context, url string, queues []Queue, opts AMQPConsumerOptions, log logger) *AMQPConsumer {
 	ctx, cancel := context.WithCancel(ctx)
 
 	c := &AMQPConsumer{
 		url:    url,
 		queues: queues,
+		opts:   opts,
 		log:    log,
 		ctx:    ctx,
 		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 
 	c.run()
@@ -49,14 +76,20 @@ func NewAMQPConsumer(ctx context.Context, url string, queues []Queue, log logger
 // Run message processing routine
 func (c *AMQPConsumer) run() {
 	c.wg.Add(1)
-	b := &backOff{}
+	b := NewBackOff(c.opts.Reconnect)
 
 	go func() {
 		defer c.wg.Done()
+		defer close(c.done)
+
+		// number of failed connection attempts in a row and time since connection is down
+		var attempts int
+		down := time.Now()
 
 		// re-connect loop: re-initialize connection to AMQP server in case an error occurs
 		for {
-			if err := c.serve(); err != nil {
+			connected, err := c.serve()
+			if err != nil {
 				c.log.Errorf("An error occurred while serving AMQP connection: %v", err)
 			}
 
@@ -64,6 +97,21 @@ func (c *AMQPConsumer) run() {
 				return
 			}
 
+			if connected {
+				attempts = 0
+				down = time.Now()
+			} else {
+				attempts++
+			}
+
+			if err := c.giveUp(attempts, time.Since(down), err); err != nil {
+				c.log.Errorf("Giving up re-connecting to AMQP server: %v", err)
+				c.err = err
+				c.cancel()
+
+				return
+			}
+
 			t := b.Timeout()
 
 			c.log.Infof("Waiting %v before re-connect", t)
@@ -75,6 +123,42 @@ func (c *AMQPConsumer) run() {
 	}()
 }
 
+// Check if consumer should stop re-connecting to AMQP server
+func (c *AMQPConsumer) giveUp(attempts int, downtime time.Duration, err error) error {
+	if c.opts.MaxReconnectAttempts > 0 && attempts >= c.opts.MaxReconnectAttempts {
+		return fmt.Errorf("unable to connect after %v attempts: %v", attempts, err)
+	}
+
+	if c.opts.MaxDowntime > 0 && downtime >= c.opts.MaxDowntime {
+		return fmt.Errorf("connection is down for %v: %v", downtime.Round(time.Second), err)
+	}
+
+	return nil
+}
+
+// Err returns an error which caused consumer to stop, it should be checked once consumer is done
+func (c *AMQPConsumer) Err() error {
+	return c.err
+}
+
+// Done returns channel which is closed when AMQP consumer has stopped, either because it was stopped or because all
+// queues were drained
+func (c *AMQPConsumer) Done() <-chan struct{} {
+	return c.done
+}
+
+// Failed returns number of messages which failed to process in drain mode
+func (c *AMQPConsumer) Failed() int {
+	var n int
+	for _, q := range c.queues {
+		if q.Drain != nil {
+			n += q.Drain.Failed()
+		}
+	}
+
+	return n
+}
+
 // Stop AMQP consumer and wait for all routines to gracefully finish
 func (c *AMQPConsumer) Stop() {
 	c.cancel()
@@ -82,12 +166,12 @@ func (c *AMQPConsumer) Stop() {
 }
 
 // Serve handles AMQP connection attempt. When this method returns all resources used for serving connection should be
-// released: go routines stopped, connections closed etc.
-func (c *AMQPConsumer) serve() error {
+// released: go routines stopped, connections closed etc. Returns true if connection has been established.
+func (c *AMQPConsumer) serve() (bool, error) {
 	c.log.Infof("Connecting to AMQP server")
 	conn, err := amqp.Dial(c.url)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	defer c.log.Infof("AMQP connection was closed")
@@ -102,24 +186,60 @@ func (c *AMQPConsumer) serve() error {
 	// create context for current connection attempt
 	ctx, cancel := context.WithCancel(c.ctx)
 
+	// publishing is suspended while server keeps connection blocked
+	fl := c.watchBlocked(conn)
+
 	for _, queue := range c.queues {
 		wg.Add(1)
 
 		go func(queue Queue) {
 			defer wg.Done()
 
-			b := &backOff{}
+			b := NewBackOff(c.opts.Restart)
+
+			// queue is considered deleted when it can not be found after server has cancelled consumer, consumer keeps
+			// re-starting until queue is declared again
+			var cancelled, deleted bool
 
 			// consumer re-start loop: restarts consumer in case an error occurs
 			for {
-				if err := c.consume(ctx, queue, conn); err != nil {
+				if queue.drained() || !c.waitOpen(ctx, queue) {
+					return
+				}
+
+				err := c.consume(ctx, queue, conn, fl)
+
+				switch {
+				case isNotFound(err) && (cancelled || deleted):
+					if !deleted {
+						c.log.Errorf("Queue %v has been deleted, waiting for it to be declared again", queue.Name)
+					}
+
+					deleted = true
+				case err != nil:
 					c.log.Errorf("An error occurred while consuming messages from %v: %v", queue.Name, err)
+					deleted = false
+				default:
+					deleted = false
 				}
 
-				if isStopping(ctx) {
+				cancelled = err == ErrConsumerCancelled
+
+				// there is nothing to drain when queue is deleted
+				if deleted && queue.Drain != nil {
+					c.log.Infof("Consumer for queue %v is drained: queue has been deleted", queue.Name)
+					queue.Drain.finish()
+				}
+
+				if isStopping(ctx) || queue.drained() {
 					return
 				}
 
+				// consumer has been stopped by a gate, it will be re-started as soon as gate opens
+				if ok, _ := queue.open(); !ok {
+					continue
+				}
+
 				t := b.Timeout()
 
 				c.log.Infof("Waiting %v before re-starting consumer for %v", t, queue.Name)
@@ -131,6 +251,13 @@ func (c *AMQPConsumer) serve() error {
 		}(queue)
 	}
 
+	// queue consumers only stop on their own when queues are drained
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
 	// handle connection closing notification
 	closing := make(chan *amqp.Error)
 	conn.NotifyClose(closing)
@@ -138,15 +265,22 @@ func (c *AMQPConsumer) serve() error {
 	select {
 	case err := <-closing:
 		cancel()
-		return err
+		return true, err
+	case <-drained:
+		if !isStopping(ctx) {
+			c.log.Infof("All queues are drained, stopping")
+			c.cancel()
+		}
+
+		return true, nil
 	case <-ctx.Done():
-		return nil
+		return true, nil
 	}
 }
 
 // Consume messages from individual queue. When this method returns all resources used by individual queue consumer
 // should be released: go routines stopped, connections closed etc.
-func (c *AMQPConsumer) consume(ctx context.Context, queue Queue, conn *amqp.Connection) error {
+func (c *AMQPConsumer) consume(ctx context.Context, queue Queue, conn *amqp.Connection, fl *flow) (err error) {
 	c.log.Infof("Starting consumer for queue %v", queue.Name)
 	ch, err := conn.Channel()
 	if err != nil {
@@ -156,18 +290,77 @@ func (c *AMQPConsumer) consume(ctx context.Context, queue Queue, conn *amqp.Conn
 	defer c.log.Infof("Consumer for queue %v has stopped", queue.Name)
 	defer ch.Close()
 
+	// server cancels consumer when queue is deleted or fails over to another node, and closes channel on errors,
+	// consumer is re-started in both cases
+	cancelled := ch.NotifyCancel(make(chan string, 1))
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	// server's reason is more accurate than an error returned by channel operation which failed because of it
+	defer func() {
+		if e := interrupted(cancelled, closed); e != nil {
+			err = e
+		}
+	}()
+
 	if err := ch.Qos(queue.Prefetch, 0, false); err != nil {
 		return err
 	}
 
-	dv, err := ch.Consume(queue.Name, "", false, false, false, false, amqp.Table{})
+	// adaptive concurrency limit is reflected in channel prefetch, so messages which can not be processed yet stay
+	// in the queue and can be delivered to other consumers
+	if queue.Adaptive != nil {
+		if err := ch.Qos(queue.Adaptive.Limit(), 0, true); err != nil {
+			return err
+		}
+
+		queue.Adaptive.OnChange(func(limit int) {
+			c.log.Debugf("Concurrency limit for queue %v changed to %v", queue.Name, limit)
+
+			if err := ch.Qos(limit, 0, true); err != nil {
+				c.log.Errorf("Unable to update prefetch for queue %v: %v", queue.Name, err)
+			}
+		})
+
+		defer queue.Adaptive.OnChange(nil)
+	}
+
+	// messages are moved to parking queue using the same channel, publishing is confirmed before message is acked
+	var pub *publisher
+
+	if queue.ParkingQueue != "" {
+		if pub, err = newPublisher(ch, fl, c.log); err != nil {
+			return err
+		}
+	}
+
+	tag := consumerTag()
+
+	dv, err := ch.Consume(queue.Name, tag, false, false, false, false, amqp.Table{})
 	if err != nil {
 		return err
 	}
 
+	// cancel subscription when any of the queue gates closes, delivery channel is closed once subscription is cancelled
+	// and consumer stops after messages in processing are finished
+	done := make(chan struct{})
+	defer close(done)
+
+	c.watchGates(queue, done, func() {
+		if err := ch.Cancel(tag, false); err != nil {
+			c.log.Errorf("Unable to cancel consumer for queue %v: %v", queue.Name, err)
+		}
+	})
+
+	dv = c.drain(queue, ch, tag, dv, done)
+
+	if queue.Batch != nil {
+		return c.consumeBatches(ctx, queue, dv)
+	}
+
 	eg, ctx := errgroup.WithContext(ctx)
 
 	sem := make(chan struct{}, queue.Parallelism)
+	parts := newPartitions()
 
 loop:
 	for {
@@ -179,58 +372,268 @@ loop:
 				break loop
 			}
 
-			sem <- struct{}{}
+			var key string
+			if queue.PartitionKey != nil {
+				key = queue.PartitionKey(d)
+			}
+
+			// messages with the same partition key are processed in the order they were received, message waits for
+			// previous message with the same key before taking a parallelism slot
+			prev, done := parts.next(key)
+			if prev == nil {
+				sem <- struct{}{}
+			}
 
 			eg.Go(func() error {
+				defer parts.release(key, done)
+
+				if prev != nil {
+					<-prev
+					sem <- struct{}{}
+				}
+
 				defer func() {
 					<-sem
 				}()
 
-				logctx := map[string]interface{}{
-					"message_id":   d.MessageId,
-					"delivery_tag": d.DeliveryTag,
+				// messages with partition key are re-tried in place, so following messages with the same key are not
+				// processed before re-delivered message
+				for retries := 0; ; retries++ {
+					if err := c.process(ctx, pub, queue, d, retries); err != errRetry {
+						return err
+					}
 				}
+			})
+		}
+	}
 
-				c.log.Debug("Processing message", logctx)
+	return eg.Wait()
+}
 
-				err := queue.Processor(ctx, headers(d), d.Body)
-				switch err {
-				case nil: // 2xx
-					c.log.Debug("Message successfully processed", logctx)
+// errRetry is returned by process when message should be processed again
+var errRetry = errors.New("retry message processing")
 
-					if err := d.Ack(false); err != nil {
-						return err
-					}
-				case ErrProcessingError: // 4xx error
-					c.log.Debug(fmt.Sprintf("Message processed with error: %v", err), logctx)
+// Process message and settle it according to processing result, retries is the number of times message was already
+// re-tried in place
+func (c *AMQPConsumer) process(ctx context.Context, pub *publisher, queue Queue, d amqp.Delivery, retries int) error {
+	// message is left unacked when consumer is stopping, so it's returned to the queue
+	if err := queue.throttle(ctx, d); err != nil {
+		return nil
+	}
 
-					if err := d.Reject(false); err != nil {
-						return err
-					}
-				case ErrProcessingFailed: // 5xx error
-					fallthrough
-				case ErrUnknownStatus: // status code is missing (could be 2xx, could be fatal error)
-					fallthrough
-				case ErrProcessorInternal: // could not perform request
-					fallthrough
-				default:
-					t := queue.FailureTimeout
-					c.log.Error(fmt.Sprintf("Message processing failed: %v. Waiting %v before putting message back to the queue.", err, t), logctx)
+	if err := queue.acquire(ctx); err != nil {
+		return nil
+	}
 
-					// wait a bit before putting message back to the queue
-					wait(ctx, t)
+	logctx := map[string]interface{}{
+		"message_id":   d.MessageId,
+		"delivery_tag": d.DeliveryTag,
+	}
 
-					if err := d.Reject(true); err != nil {
-						return err
-					}
-				}
+	c.log.Debug("Processing message", logctx)
 
+	mctx := withDelivery(ctx, d)
+
+	start := time.Now()
+	err := queue.Processor(mctx, headers(d, queue.Headers), d.Body)
+	queue.release(time.Since(start), err)
+
+	if rej, ok := err.(*RejectionError); ok {
+		c.log.Debug(fmt.Sprintf("Message rejected: %v", rej.Reason), logctx)
+
+		return c.reject(ctx, pub, queue, d, rej)
+	}
+
+	switch err {
+	case nil: // 2xx
+		c.log.Debug("Message successfully processed", logctx)
+
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+
+		acked(mctx)
+	case ErrProcessingError: // 4xx error
+		c.log.Debug(fmt.Sprintf("Message processed with error: %v", err), logctx)
+
+		queue.fail(1)
+
+		if err := d.Reject(false); err != nil {
+			return err
+		}
+	case ErrProcessingInterrupted: // consumer is stopping
+		// message is left unacked, so it's returned to the queue
+		c.log.Debug("Message processing interrupted", logctx)
+	case ErrProcessingFailed: // 5xx error
+		fallthrough
+	case ErrUnknownStatus: // status code is missing (could be 2xx, could be fatal error)
+		fallthrough
+	case ErrProcessorInternal: // could not perform request
+		fallthrough
+	default:
+		t := queue.requeueDelay(retries + redeliveries(d))
+
+		queue.fail(1)
+
+		if queue.PartitionKey != nil {
+			c.log.Error(fmt.Sprintf("Message processing failed: %v. Waiting %v before re-trying.", err, t), logctx)
+
+			// message is left unacked when consumer is stopping, so it's returned to the queue
+			if isStoppingWithTimeout(ctx, t) {
 				return nil
-			})
+			}
+
+			return errRetry
+		}
+
+		c.log.Error(fmt.Sprintf("Message processing failed: %v. Waiting %v before putting message back to the queue.", err, t), logctx)
+
+		// wait a bit before putting message back to the queue
+		wait(ctx, t)
+
+		if err := d.Reject(true); err != nil {
+			return err
 		}
 	}
 
-	return eg.Wait()
+	return nil
+}
+
+// Wait until message can be processed according to rate limit
+func (q Queue) throttle(ctx context.Context, d amqp.Delivery) error {
+	if q.RateLimit == nil {
+		return nil
+	}
+
+	return q.RateLimit.Wait(ctx, d)
+}
+
+// Acquire slot in adaptive and shared concurrency limits
+func (q Queue) acquire(ctx context.Context) error {
+	if q.Adaptive != nil {
+		if err := q.Adaptive.Acquire(ctx); err != nil {
+			return err
+		}
+	}
+
+	if q.Limit != nil {
+		if err := q.Limit.Acquire(ctx, q.Name, q.Weight); err != nil {
+			if q.Adaptive != nil {
+				q.Adaptive.Cancel()
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Release slot in adaptive and shared concurrency limits, processing latency and error are used to adjust adaptive limit
+func (q Queue) release(latency time.Duration, err error) {
+	if q.Limit != nil {
+		q.Limit.Release()
+	}
+
+	if q.Adaptive != nil {
+		q.Adaptive.Release(latency, err)
+	}
+}
+
+// Delay before failed message is put back to the queue, delay grows with number of times message was re-delivered
+func (q Queue) requeueDelay(retries int) time.Duration {
+	if q.Requeue != nil {
+		return q.Requeue.Delay(retries)
+	}
+
+	return q.FailureTimeout
+}
+
+// Reject message without processing, message is moved to parking queue if it's configured or rejected otherwise
+func (c *AMQPConsumer) reject(ctx context.Context, pub *publisher, queue Queue, d amqp.Delivery, rej *RejectionError) error {
+	if queue.ParkingQueue == "" {
+		return d.Reject(false)
+	}
+
+	h := amqp.Table{}
+	for k, v := range d.Headers {
+		h[k] = v
+	}
+
+	for k, v := range rej.Headers {
+		h[k] = v
+	}
+
+	h["x-rejection-reason"] = rej.Reason
+	h["x-original-queue"] = queue.Name
+
+	confirmed, err := pub.publish(ctx, queue.ParkingQueue, amqp.Publishing{
+		Headers:         h,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+
+	// message is left unacked when consumer is stopping, so it's returned to the queue
+	if err != nil && isStopping(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !confirmed {
+		c.log.Errorf("Message moved to parking queue %v was not confirmed or routed by server, putting it back to the queue", queue.ParkingQueue)
+		return d.Nack(false, true)
+	}
+
+	return d.Ack(false)
+}
+
+// isNotFound checks if channel was closed by server because queue does not exist
+func isNotFound(err error) bool {
+	e, ok := err.(*amqp.Error)
+	return ok && e.Code == amqp.NotFound
+}
+
+// interrupted checks if subscription has ended because server cancelled consumer or closed channel, channel closing
+// is reported as *amqp.Error so the reason can be inspected
+func interrupted(cancelled <-chan string, closed <-chan *amqp.Error) error {
+	select {
+	case err, ok := <-closed:
+		if ok && err != nil {
+			return err
+		}
+	default:
+	}
+
+	select {
+	case _, ok := <-cancelled:
+		if ok {
+			return ErrConsumerCancelled
+		}
+	default:
+	}
+
+	return nil
+}
+
+var consumerSeq uint64
+
+// consumerTag generates unique consumer tag
+func consumerTag() string {
+	return fmt.Sprintf("amqp-cgi-bridge-%v-%v", os.Getpid(), atomic.AddUint64(&consumerSeq, 1))
 }
 
 func wait(ctx context.Context, duration time.Duration) {
@@ -258,30 +661,41 @@ func isStoppingWithTimeout(ctx context.Context, duration time.Duration) bool {
 	}
 }
 
-func headers(d amqp.Delivery) map[string]string {
-	h := map[string]string{
-		"CONTENT_TYPE":     d.ContentType,
-		"CONTENT_ENCODING": d.ContentEncoding,
-		"DELIVERY_MODE":    fmt.Sprint(d.DeliveryMode),
-		"PRIORITY":         fmt.Sprint(d.Priority),
-		"CORRELATION_ID":   d.CorrelationId,
-		"REPLY_TO":         d.ReplyTo,
-		"EXPIRATION":       d.Expiration,
-		"MESSAGE_ID":       d.MessageId,
-		"TIMESTAMP":        fmt.Sprint(d.Timestamp),
-		"TYPE":             d.Type,
-		"USER_ID":          d.UserId,
-		"APP_ID":           d.AppId,
-		"CONSUMER_TAG":     d.ConsumerTag,
-		"DELIVERY_TAG":     fmt.Sprint(d.DeliveryTag),
-		"REDELIVERED":      fmt.Sprint(d.Redelivered),
-		"EXCHANGE":         d.Exchange,
-		"ROUTING_KEY":      d.RoutingKey,
+type deliveryKey struct{}
+
+// message is attached to processor context, it holds original AMQP delivery and functions to run after message is acked
+type message struct {
+	delivery amqp.Delivery
+	acked    []func()
+}
+
+// withDelivery attaches AMQP delivery to the context, so processors can access original message
+func withDelivery(ctx context.Context, d amqp.Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, &message{delivery: d})
+}
+
+// deliveryFromContext returns AMQP delivery attached to the context
+func deliveryFromContext(ctx context.Context) (amqp.Delivery, bool) {
+	m, ok := ctx.Value(deliveryKey{}).(*message)
+	if !ok {
+		return amqp.Delivery{}, false
 	}
 
-	for k, v := range d.Headers {
-		h["AMQP_"+strings.ToUpper(k)] = fmt.Sprint(v)
+	return m.delivery, true
+}
+
+// onAck registers function to run after message has been successfully processed and acked
+func onAck(ctx context.Context, fn func()) {
+	if m, ok := ctx.Value(deliveryKey{}).(*message); ok {
+		m.acked = append(m.acked, fn)
 	}
+}
 
-	return h
+// acked runs functions registered with onAck
+func acked(ctx context.Context) {
+	if m, ok := ctx.Value(deliveryKey{}).(*message); ok {
+		for _, fn := range m.acked {
+			fn()
+		}
+	}
 }
@@ -0,0 +1,131 @@
+package bridge
+
+import (
+	"github.com/streadway/amqp"
+	"sync/atomic"
+	"time"
+)
+
+// Drain makes queue consumer stop once queue is empty or enough messages were received, instead of waiting for new
+// messages forever. Drain state is kept between consumer re-starts, so consumer does not start again once drained.
+type Drain struct {
+	IdleTimeout time.Duration
+	MaxMessages int
+
+	received int64
+	failed   int64
+	drained  int32
+}
+
+// NewDrain creates drain which stops consumer when queue reports no messages and no deliveries arrive for idle
+// timeout, or when max messages were received (zero means no limit)
+func NewDrain(idleTimeout time.Duration, maxMessages int) *Drain {
+	return &Drain{IdleTimeout: idleTimeout, MaxMessages: maxMessages}
+}
+
+// Drained returns true if consumer has finished draining the queue
+func (d *Drain) Drained() bool {
+	return atomic.LoadInt32(&d.drained) == 1
+}
+
+// Failed returns number of messages which failed to process
+func (d *Drain) Failed() int {
+	return int(atomic.LoadInt64(&d.failed))
+}
+
+func (d *Drain) fail(n int) {
+	atomic.AddInt64(&d.failed, int64(n))
+}
+
+func (d *Drain) finish() {
+	atomic.StoreInt32(&d.drained, 1)
+}
+
+// Check if queue consumer has finished draining the queue
+func (q Queue) drained() bool {
+	return q.Drain != nil && q.Drain.Drained()
+}
+
+// Record failed message processing
+func (q Queue) fail(n int) {
+	if q.Drain != nil {
+		q.Drain.fail(n)
+	}
+}
+
+// drain forwards deliveries until queue is drained, then cancels subscription and closes returned channel. Forwarding
+// stops when done channel is closed.
+func (c *AMQPConsumer) drain(queue Queue, ch *amqp.Channel, tag string, dv <-chan amqp.Delivery, done <-chan struct{}) <-chan amqp.Delivery {
+	if queue.Drain == nil {
+		return dv
+	}
+
+	out := make(chan amqp.Delivery)
+
+	go func() {
+		defer close(out)
+
+		d := queue.Drain
+		finish := func(reason string) {
+			c.log.Infof("Consumer for queue %v is drained: %v", queue.Name, reason)
+			d.finish()
+
+			// messages which were delivered, but not forwarded are returned to the queue when channel is closed
+			if err := ch.Cancel(tag, false); err != nil {
+				c.log.Errorf("Unable to cancel consumer for queue %v: %v", queue.Name, err)
+			}
+		}
+
+		if d.MaxMessages > 0 && atomic.LoadInt64(&d.received) >= int64(d.MaxMessages) {
+			finish("max messages received")
+			return
+		}
+
+		idle := time.NewTimer(d.IdleTimeout)
+		defer idle.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-idle.C:
+				// passive queue declare reports number of messages ready for delivery
+				q, err := ch.QueueInspect(queue.Name)
+				if err != nil {
+					c.log.Errorf("Unable to inspect queue %v: %v", queue.Name, err)
+					return
+				}
+
+				if q.Messages == 0 {
+					finish("queue is empty")
+					return
+				}
+
+				idle.Reset(d.IdleTimeout)
+			case m, ok := <-dv:
+				if !ok {
+					return
+				}
+
+				select {
+				case out <- m:
+				case <-done:
+					return
+				}
+
+				if n := atomic.AddInt64(&d.received, 1); d.MaxMessages > 0 && n >= int64(d.MaxMessages) {
+					finish("max messages received")
+					return
+				}
+
+				if !idle.Stop() {
+					<-idle.C
+				}
+
+				idle.Reset(d.IdleTimeout)
+			}
+		}
+	}()
+
+	return out
+}
@@ -6,3 +6,16 @@ var ErrProcessorInternal = errors.New("processor was not able to perform request
 var ErrUnknownStatus = errors.New("processor was not able to read response status code")
 var ErrProcessingError = errors.New("request to processing backend has failed (response status code 3xx or 4xx)")
 var ErrProcessingFailed = errors.New("message processing failed (response status code 5xx)")
+var ErrProcessingInterrupted = errors.New("message processing interrupted because consumer is stopping")
+var ErrConsumerCancelled = errors.New("consumer cancelled by server, queue was deleted or failed over")
+
+// RejectionError is returned by processor when message should be rejected without being processed. If parking queue
+// is configured, message is moved to parking queue with additional headers attached.
+type RejectionError struct {
+	Reason  string
+	Headers map[string]interface{}
+}
+
+func (e *RejectionError) Error() string {
+	return "message rejected: " + e.Reason
+}
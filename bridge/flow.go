@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"sync"
+)
+
+// flow tracks connection.blocked notifications sent by server when it's running low on resources, publishing is
+// suspended while connection is blocked
+type flow struct {
+	mu        sync.Mutex
+	unblocked chan struct{}
+}
+
+// block suspends publishing
+func (f *flow) block() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unblocked == nil {
+		f.unblocked = make(chan struct{})
+	}
+}
+
+// unblock resumes publishing
+func (f *flow) unblock() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unblocked != nil {
+		close(f.unblocked)
+		f.unblocked = nil
+	}
+}
+
+// wait until connection is unblocked
+func (f *flow) wait(ctx context.Context) error {
+	f.mu.Lock()
+	unblocked := f.unblocked
+	f.mu.Unlock()
+
+	if unblocked == nil {
+		return nil
+	}
+
+	select {
+	case <-unblocked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchBlocked subscribes to connection blocked notifications, watching stops when connection is closed
+func (c *AMQPConsumer) watchBlocked(conn *amqp.Connection) *flow {
+	f := &flow{}
+	blocks := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+
+	go func() {
+		for b := range blocks {
+			if b.Active {
+				c.log.Errorf("AMQP connection is blocked by server, publishing is suspended: %v", b.Reason)
+				f.block()
+			} else {
+				c.log.Infof("AMQP connection is unblocked by server, publishing is resumed")
+				f.unblock()
+			}
+		}
+	}()
+
+	return f
+}
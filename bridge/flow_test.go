@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFlow(t *testing.T) {
+	f := &flow{}
+
+	if err := f.wait(context.Background()); err != nil {
+		t.Fatalf("Wait should return immediately when connection is not blocked: %v", err)
+	}
+
+	f.block()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := f.wait(ctx); err == nil {
+		t.Fatalf("Wait should block while connection is blocked")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.unblock()
+	}()
+
+	if err := f.wait(context.Background()); err != nil {
+		t.Fatalf("Wait should return when connection is unblocked: %v", err)
+	}
+}
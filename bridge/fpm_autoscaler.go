@@ -0,0 +1,244 @@
+package bridge
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/tomasen/fcgi_client"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// FPMStatus is PHP-FPM pool status reported by pm.status_path page
+type FPMStatus struct {
+	Pool               string `json:"pool"`
+	ListenQueue        int    `json:"listen queue"`
+	MaxListenQueue     int    `json:"max listen queue"`
+	IdleProcesses      int    `json:"idle processes"`
+	ActiveProcesses    int    `json:"active processes"`
+	TotalProcesses     int    `json:"total processes"`
+	MaxActiveProcesses int    `json:"max active processes"`
+	MaxChildrenReached int    `json:"max children reached"`
+	SlowRequests       int    `json:"slow requests"`
+}
+
+// FetchFPMStatus requests PHP-FPM status page over FastCGI
+func FetchFPMStatus(net, addr, path string) (FPMStatus, error) {
+	var status FPMStatus
+
+	conn, err := fcgiclient.Dial(net, addr)
+	if err != nil {
+		return status, err
+	}
+
+	defer conn.Close()
+
+	resp, err := conn.Get(map[string]string{
+		"SCRIPT_NAME":     path,
+		"SCRIPT_FILENAME": path,
+		"REQUEST_URI":     path + "?json",
+		"QUERY_STRING":    "json",
+	})
+
+	if err != nil {
+		return status, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return status, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return status, fmt.Errorf("status page responded with status code %v", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("unable to parse status page: %v", err)
+	}
+
+	return status, nil
+}
+
+// fpmMetrics exports last known status of every polled PHP-FPM status page, keyed by address and status path
+var fpmMetrics = expvar.NewMap("phpfpm")
+
+// FPMStatusPoller periodically fetches PHP-FPM status page and passes it to autoscalers, status page is polled once
+// regardless of the number of consumers using it
+type FPMStatusPoller struct {
+	net      string
+	addr     string
+	path     string
+	interval time.Duration
+	log      logger
+	mu       sync.Mutex
+	status   FPMStatus
+	scalers  []*FPMAutoscaler
+	turn     int
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFPMStatusPoller constructs poller, starts status polling routine and exports status as "phpfpm" metrics
+func NewFPMStatusPoller(net, addr, path string, interval time.Duration, log logger) *FPMStatusPoller {
+	p := &FPMStatusPoller{
+		net:      net,
+		addr:     addr,
+		path:     path,
+		interval: interval,
+		log:      log,
+		stop:     make(chan struct{}),
+	}
+
+	fpmMetrics.Set(net+"://"+addr+path, expvar.Func(func() interface{} {
+		return p.Status()
+	}))
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.poll()
+			}
+		}
+	}()
+
+	return p
+}
+
+// Interval between status page requests
+func (p *FPMStatusPoller) Interval() time.Duration {
+	return p.interval
+}
+
+// Status returns last fetched PHP-FPM status
+func (p *FPMStatusPoller) Status() FPMStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.status
+}
+
+// Stop status polling routine
+func (p *FPMStatusPoller) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// poll PHP-FPM status and scale concurrency limits
+func (p *FPMStatusPoller) poll() {
+	status, err := FetchFPMStatus(p.net, p.addr, p.path)
+	if err != nil {
+		p.log.Errorf("Unable to fetch PHP-FPM status: %v", err)
+		return
+	}
+
+	p.log.Debug("PHP-FPM status", map[string]interface{}{
+		"pool":                 status.Pool,
+		"listen_queue":         status.ListenQueue,
+		"max_listen_queue":     status.MaxListenQueue,
+		"idle_processes":       status.IdleProcesses,
+		"active_processes":     status.ActiveProcesses,
+		"total_processes":      status.TotalProcesses,
+		"max_active_processes": status.MaxActiveProcesses,
+		"max_children_reached": status.MaxChildrenReached,
+		"slow_requests":        status.SlowRequests,
+	})
+
+	p.mu.Lock()
+	p.status = status
+	p.mu.Unlock()
+
+	p.scale(status)
+}
+
+// scale concurrency limits of all autoscalers according to PHP-FPM status, autoscalers share the same PHP-FPM pool so
+// the correction is split between them instead of being applied by every one of them
+func (p *FPMStatusPoller) scale(status FPMStatus) {
+	p.mu.Lock()
+	scalers := p.scalers
+	turn := p.turn
+	p.turn++
+	p.mu.Unlock()
+
+	if len(scalers) == 0 {
+		return
+	}
+
+	total := 0
+	for _, s := range scalers {
+		total += s.limit.Limit()
+	}
+
+	delta := fpmTarget(status, total) - total
+
+	// remainder goes to a different autoscaler every time, so all of them get a chance to grow
+	for i, s := range scalers {
+		if d := fpmShare(delta, (i+turn)%len(scalers), len(scalers)); d != 0 {
+			s.limit.SetLimit(s.limit.Limit() + d)
+		}
+	}
+}
+
+// FPMAutoscaler adjusts concurrency limit according to PHP-FPM status to keep FPM listen queue near zero:
+// limit is decreased while requests are waiting in the listen queue and increased while there are idle processes
+type FPMAutoscaler struct {
+	limit *AdaptiveConcurrency
+}
+
+// NewFPMAutoscaler constructs autoscaler and registers it with PHP-FPM status poller
+func NewFPMAutoscaler(poller *FPMStatusPoller, limit *AdaptiveConcurrency) *FPMAutoscaler {
+	s := &FPMAutoscaler{
+		limit: limit,
+	}
+
+	poller.mu.Lock()
+	poller.scalers = append(poller.scalers, s)
+	poller.mu.Unlock()
+
+	return s
+}
+
+// fpmShare returns part of delta for i-th of n autoscalers, remainder is split between the first autoscalers
+func fpmShare(delta, i, n int) int {
+	share := delta / n
+	r := delta % n
+
+	if r > 0 && i < r {
+		share++
+	}
+
+	if r < 0 && i < -r {
+		share--
+	}
+
+	return share
+}
+
+// fpmTarget calculates desired concurrency limit according to PHP-FPM status
+func fpmTarget(status FPMStatus, limit int) int {
+	if status.ListenQueue > 0 {
+		return limit - status.ListenQueue
+	}
+
+	if status.IdleProcesses > 0 {
+		inc := status.IdleProcesses / 2
+		if inc < 1 {
+			inc = 1
+		}
+
+		return limit + inc
+	}
+
+	return limit
+}
@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFPMTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		status FPMStatus
+		limit  int
+		want   int
+	}{
+		{name: "listen queue", status: FPMStatus{ListenQueue: 3}, limit: 10, want: 7},
+		{name: "idle processes", status: FPMStatus{IdleProcesses: 6}, limit: 10, want: 13},
+		{name: "single idle process", status: FPMStatus{IdleProcesses: 1}, limit: 10, want: 11},
+		{name: "busy", status: FPMStatus{ActiveProcesses: 10}, limit: 10, want: 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fpmTarget(test.status, test.limit); got != test.want {
+				t.Errorf("Target concurrency does not match: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestFetchFPMStatus(t *testing.T) {
+	addr := os.Getenv("TEST_PHPFPM_ADDR")
+	path := os.Getenv("TEST_PHPFPM_STATUS_PATH")
+	if addr == "" || path == "" {
+		t.Skip("This test requires PHP-FPM server with status page, use environment variables TEST_PHPFPM_ADDR and TEST_PHPFPM_STATUS_PATH to set PHP-FPM address and pm.status_path.")
+	}
+
+	status, err := FetchFPMStatus("tcp", addr, path)
+	if err != nil {
+		t.Fatalf("Unable to fetch PHP-FPM status: %v", err)
+	}
+
+	if status.TotalProcesses == 0 {
+		t.Errorf("PHP-FPM status should report at least one process")
+	}
+}
+
+func TestScaledConcurrency(t *testing.T) {
+	a := NewScaledConcurrency(2, 10)
+
+	for i := 0; i < 10; i++ {
+		saturate(t, a)
+		a.Release(0, ErrProcessorInternal)
+	}
+
+	if a.Limit() != 2 {
+		t.Errorf("Scaled concurrency limit should not be adjusted on release, got %v", a.Limit())
+	}
+
+	a.SetLimit(20)
+
+	if a.Limit() != 10 {
+		t.Errorf("Scaled concurrency limit should be bounded by maximum, got %v", a.Limit())
+	}
+}
+
+func TestFPMAutoscaler(t *testing.T) {
+	p := &FPMStatusPoller{}
+	a := NewScaledConcurrency(2, 10)
+	a.SetLimit(5)
+
+	NewFPMAutoscaler(p, a)
+
+	p.scale(FPMStatus{ListenQueue: 2})
+
+	if a.Limit() != 3 {
+		t.Errorf("Concurrency limit should be decreased while requests are waiting in listen queue, got %v", a.Limit())
+	}
+
+	p.scale(FPMStatus{IdleProcesses: 4})
+
+	if a.Limit() != 5 {
+		t.Errorf("Concurrency limit should be increased while there are idle processes, got %v", a.Limit())
+	}
+}
+
+// autoscalers sharing PHP-FPM pool should split the correction instead of applying it every one
+func TestFPMAutoscaler_Shared(t *testing.T) {
+	p := &FPMStatusPoller{}
+	a := NewScaledConcurrency(1, 20)
+	b := NewScaledConcurrency(1, 20)
+	a.SetLimit(10)
+	b.SetLimit(10)
+
+	NewFPMAutoscaler(p, a)
+	NewFPMAutoscaler(p, b)
+
+	p.scale(FPMStatus{ListenQueue: 10})
+
+	if a.Limit()+b.Limit() != 10 {
+		t.Errorf("Total concurrency limit should be decreased by listen queue length, got %v and %v", a.Limit(), b.Limit())
+	}
+
+	// single idle process allows to increase total limit by one
+	p.scale(FPMStatus{IdleProcesses: 1})
+
+	if a.Limit()+b.Limit() != 11 {
+		t.Errorf("Total concurrency limit should be increased by one, got %v and %v", a.Limit(), b.Limit())
+	}
+
+	p.scale(FPMStatus{IdleProcesses: 1})
+
+	if a.Limit() != 6 || b.Limit() != 6 {
+		t.Errorf("Concurrency limit should be increased for every autoscaler in turn, got %v and %v", a.Limit(), b.Limit())
+	}
+}
+
+func TestFPMShare(t *testing.T) {
+	tests := []struct {
+		delta int
+		n     int
+		want  []int
+	}{
+		{delta: -10, n: 3, want: []int{-4, -3, -3}},
+		{delta: 5, n: 2, want: []int{3, 2}},
+		{delta: 1, n: 3, want: []int{1, 0, 0}},
+		{delta: 0, n: 2, want: []int{0, 0}},
+	}
+	for _, test := range tests {
+		for i, want := range test.want {
+			if got := fpmShare(test.delta, i, test.n); got != want {
+				t.Errorf("Share of %v for autoscaler %v of %v does not match: want %v, got %v", test.delta, i, test.n, want, got)
+			}
+		}
+	}
+}
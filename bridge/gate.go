@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"context"
+	"time"
+)
+
+// how often queue gates are checked
+const gatePollInterval = time.Second
+
+// Gate controls whether queue consumer is allowed to consume messages. When gate closes consumer cancels its
+// subscription and lets messages in processing finish, when gate opens again consumer re-subscribes.
+type Gate interface {
+	// Open returns true if consumption is allowed, or false and a reason why it's not
+	Open() (bool, string)
+}
+
+// Check if all queue gates are open
+func (q Queue) open() (bool, string) {
+	for _, g := range q.Gates {
+		if ok, reason := g.Open(); !ok {
+			return false, reason
+		}
+	}
+
+	return true, ""
+}
+
+// Wait until all queue gates are open, returns false if consumer is stopping. Queue which is drained is not waited
+// for, closed gate means there is nothing to consume.
+func (c *AMQPConsumer) waitOpen(ctx context.Context, queue Queue) bool {
+	ok, reason := queue.open()
+	if ok {
+		return true
+	}
+
+	if queue.Drain != nil {
+		c.log.Infof("Consumer for queue %v is drained: %v", queue.Name, reason)
+		queue.Drain.finish()
+
+		return false
+	}
+
+	c.log.Infof("Consumer for queue %v is paused: %v", queue.Name, reason)
+
+	for !ok {
+		if isStoppingWithTimeout(ctx, gatePollInterval) {
+			return false
+		}
+
+		ok, _ = queue.open()
+	}
+
+	c.log.Infof("Consumer for queue %v is resumed", queue.Name)
+
+	return true
+}
+
+// Watch queue gates and call cancel when any of them closes, watching stops when done channel is closed
+func (c *AMQPConsumer) watchGates(queue Queue, done <-chan struct{}, cancel func()) {
+	if len(queue.Gates) == 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(gatePollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if ok, reason := queue.open(); !ok {
+					c.log.Infof("Stopping consumer for queue %v: %v", queue.Name, reason)
+					cancel()
+
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,333 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"path"
+	"strings"
+	"time"
+)
+
+// HeaderMapping defines how AMQP headers are passed to processor
+type HeaderMapping struct {
+	// Prefix added to every AMQP header name, eq. "AMQP_" or "HTTP_"
+	Prefix string
+	// Normalize header names to be CGI-safe: any character except letters, digits and underscore is replaced with underscore
+	Normalize bool
+	// Allow is a list of header name patterns to pass to processor, all headers are passed if list is empty
+	Allow []string
+	// Deny is a list of header name patterns which should never be passed to processor
+	Deny []string
+}
+
+// DefaultHeaderMapping passes all headers with "AMQP_" prefix without name normalization
+var DefaultHeaderMapping = HeaderMapping{Prefix: "AMQP_"}
+
+// reservedNames are never taken from message headers, so producer can not choose which script runs or how it's run
+var reservedNames = map[string]bool{
+	// HTTP_PROXY is used by CGI applications to configure outgoing proxy (httpoxy)
+	"HTTP_PROXY": true,
+	// CGI variables set by bridge, web server or "params"
+	"SCRIPT_FILENAME":   true,
+	"SCRIPT_NAME":       true,
+	"REQUEST_METHOD":    true,
+	"REQUEST_URI":       true,
+	"QUERY_STRING":      true,
+	"CONTENT_LENGTH":    true,
+	"CONTENT_TYPE":      true,
+	"DOCUMENT_ROOT":     true,
+	"DOCUMENT_URI":      true,
+	"PATH_INFO":         true,
+	"PATH_TRANSLATED":   true,
+	"GATEWAY_INTERFACE": true,
+	"SERVER_SOFTWARE":   true,
+	"SERVER_NAME":       true,
+	"SERVER_ADDR":       true,
+	"SERVER_PORT":       true,
+	"SERVER_PROTOCOL":   true,
+	"REMOTE_ADDR":       true,
+	"REMOTE_PORT":       true,
+	"REMOTE_USER":       true,
+	"AUTH_TYPE":         true,
+	"HTTPS":             true,
+	// PHP-FPM applies these as php.ini settings
+	"PHP_VALUE":       true,
+	"PHP_ADMIN_VALUE": true,
+	// delivery properties which are only set when known
+	"ORIGINAL_QUEUE":     true,
+	"ORIGINAL_EXCHANGE":  true,
+	"FIRST_DEATH_REASON": true,
+	"FIRST_DEATH_TIME":   true,
+	"DELIVERY_COUNT":     true,
+}
+
+// Name returns processor header name for given AMQP header, second value is false if header should be skipped
+func (m HeaderMapping) Name(header string) (string, bool) {
+	if len(m.Allow) > 0 && !matchHeader(m.Allow, header) {
+		return "", false
+	}
+
+	if matchHeader(m.Deny, header) {
+		return "", false
+	}
+
+	name := strings.ToUpper(header)
+
+	if m.Normalize {
+		name = strings.Map(func(r rune) rune {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+				return r
+			}
+
+			return '_'
+		}, name)
+	}
+
+	name = m.Prefix + name
+
+	if reservedNames[name] {
+		return "", false
+	}
+
+	return name, true
+}
+
+// matchHeader checks if header name matches any of the patterns, patterns are case-insensitive and support wildcards
+func matchHeader(patterns []string, header string) bool {
+	header = strings.ToLower(header)
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), header); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func headers(d amqp.Delivery, m *HeaderMapping) map[string]string {
+	h := map[string]string{
+		"CONTENT_TYPE":     d.ContentType,
+		"CONTENT_ENCODING": d.ContentEncoding,
+		"DELIVERY_MODE":    fmt.Sprint(d.DeliveryMode),
+		"PRIORITY":         fmt.Sprint(d.Priority),
+		"CORRELATION_ID":   d.CorrelationId,
+		"REPLY_TO":         d.ReplyTo,
+		"EXPIRATION":       d.Expiration,
+		"MESSAGE_ID":       d.MessageId,
+		"TIMESTAMP":        encodeTimestamp(d.Timestamp),
+		"TIMESTAMP_UNIX":   encodeUnixTimestamp(d.Timestamp),
+		"TYPE":             d.Type,
+		"USER_ID":          d.UserId,
+		"APP_ID":           d.AppId,
+		"CONSUMER_TAG":     d.ConsumerTag,
+		"DELIVERY_TAG":     fmt.Sprint(d.DeliveryTag),
+		"REDELIVERED":      fmt.Sprint(d.Redelivered),
+		"EXCHANGE":         d.Exchange,
+		"ROUTING_KEY":      d.RoutingKey,
+	}
+
+	if m == nil {
+		m = &DefaultHeaderMapping
+	}
+
+	deaths(d, h)
+
+	table := make(map[string]interface{}, len(d.Headers))
+
+	for k, v := range d.Headers {
+		name, ok := m.Name(k)
+		if !ok {
+			continue
+		}
+
+		table[k] = v
+
+		// headers never overwrite delivery properties, which is possible when prefix is empty
+		if _, ok := h[name]; !ok {
+			h[name] = encodeHeader(v)
+		}
+	}
+
+	if data, err := json.Marshal(table); err == nil {
+		h[m.Prefix+"HEADERS_JSON"] = string(data)
+	}
+
+	return h
+}
+
+// filterHeaders returns AMQP headers which are passed to processor according to header mapping
+func filterHeaders(t amqp.Table, m *HeaderMapping) amqp.Table {
+	if m == nil {
+		m = &DefaultHeaderMapping
+	}
+
+	filtered := make(amqp.Table, len(t))
+
+	for k, v := range t {
+		if _, ok := m.Name(k); ok {
+			filtered[k] = v
+		}
+	}
+
+	return filtered
+}
+
+// deaths exposes dead-lettering and re-delivery information parsed from "x-death" and "x-delivery-count" headers
+func deaths(d amqp.Delivery, h map[string]string) {
+	var count int64
+	var reasons []string
+	var first amqp.Table
+
+	// x-death is sorted by most recent death first, so original queue can be found in the last entry
+	xdeath, _ := d.Headers["x-death"].([]interface{})
+	for _, v := range xdeath {
+		death, ok := v.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		if c, ok := toInt64(death["count"]); ok {
+			count += c
+		}
+
+		if r, ok := death["reason"].(string); ok {
+			reasons = append(reasons, r)
+		}
+
+		first = death
+	}
+
+	h["DEATH_COUNT"] = fmt.Sprint(count)
+	h["DEATH_REASONS"] = strings.Join(reasons, ",")
+
+	if first != nil {
+		h["ORIGINAL_QUEUE"] = encodeHeader(first["queue"])
+		h["ORIGINAL_EXCHANGE"] = encodeHeader(first["exchange"])
+		h["FIRST_DEATH_REASON"] = encodeHeader(first["reason"])
+
+		if t, ok := first["time"].(time.Time); ok {
+			h["FIRST_DEATH_TIME"] = encodeTimestamp(t)
+		}
+	}
+
+	// x-first-death-* headers are more accurate when x-death entries were collapsed
+	if q, ok := d.Headers["x-first-death-queue"].(string); ok {
+		h["ORIGINAL_QUEUE"] = q
+	}
+
+	if e, ok := d.Headers["x-first-death-exchange"].(string); ok {
+		h["ORIGINAL_EXCHANGE"] = e
+	}
+
+	if r, ok := d.Headers["x-first-death-reason"].(string); ok {
+		h["FIRST_DEATH_REASON"] = r
+	}
+
+	// quorum queues track number of delivery attempts, for other queues it's only known message was never re-delivered
+	if c, ok := toInt64(d.Headers["x-delivery-count"]); ok {
+		h["DELIVERY_COUNT"] = fmt.Sprint(c)
+	} else if !d.Redelivered {
+		h["DELIVERY_COUNT"] = "0"
+	}
+}
+
+// redeliveries returns how many times message was delivered before according to "x-delivery-count" and "x-death"
+// headers, for multiple messages the highest number is returned. Classic queues do not count re-deliveries, so message
+// is only known to be re-delivered once.
+func redeliveries(dvs ...amqp.Delivery) int {
+	var max int64
+
+	for _, d := range dvs {
+		var n int64
+
+		if c, ok := toInt64(d.Headers["x-delivery-count"]); ok {
+			n = c
+		} else if d.Redelivered {
+			n = 1
+		}
+
+		xdeath, _ := d.Headers["x-death"].([]interface{})
+		for _, v := range xdeath {
+			if death, ok := v.(amqp.Table); ok {
+				if c, ok := toInt64(death["count"]); ok {
+					n += c
+				}
+			}
+		}
+
+		if n > max {
+			max = n
+		}
+	}
+
+	return int(max)
+}
+
+// toInt64 converts any integer AMQP value to int64
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// encodeHeader converts AMQP header value to a string which can be parsed by processor: complex values are encoded
+// as JSON, byte arrays as base64 and timestamps as RFC 3339
+func encodeHeader(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return encodeTimestamp(v)
+	case amqp.Table, []interface{}, amqp.Decimal:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// encodeTimestamp formats timestamp according to RFC 3339, zero timestamp is encoded as empty string
+func encodeTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// encodeUnixTimestamp formats timestamp as Unix epoch, zero timestamp is encoded as empty string
+func encodeUnixTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprint(t.Unix())
+}
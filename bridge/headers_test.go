@@ -0,0 +1,208 @@
+package bridge
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestHeaderMapping_Name(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping HeaderMapping
+		header  string
+		want    string // expected header name, empty if header should be skipped
+	}{
+		{name: "default", mapping: DefaultHeaderMapping, header: "x-tenant", want: "AMQP_X-TENANT"},
+		{name: "normalize", mapping: HeaderMapping{Prefix: "AMQP_", Normalize: true}, header: "x-tenant.id", want: "AMQP_X_TENANT_ID"},
+		{name: "http prefix", mapping: HeaderMapping{Prefix: "HTTP_", Normalize: true}, header: "x-tenant", want: "HTTP_X_TENANT"},
+		{name: "httpoxy", mapping: HeaderMapping{Prefix: "HTTP_"}, header: "Proxy", want: ""},
+		{name: "httpoxy without prefix", mapping: HeaderMapping{}, header: "http-proxy", want: "HTTP-PROXY"},
+		{name: "httpoxy normalized", mapping: HeaderMapping{Normalize: true}, header: "http-proxy", want: ""},
+		{name: "no prefix", mapping: HeaderMapping{Normalize: true}, header: "tenant", want: "TENANT"},
+		{name: "cgi variable", mapping: HeaderMapping{Normalize: true}, header: "script-filename", want: ""},
+		{name: "php ini", mapping: HeaderMapping{}, header: "php_value", want: ""},
+		{name: "allowed", mapping: HeaderMapping{Allow: []string{"X-*"}}, header: "x-tenant", want: "X-TENANT"},
+		{name: "not allowed", mapping: HeaderMapping{Allow: []string{"x-*"}}, header: "tenant", want: ""},
+		{name: "denied", mapping: HeaderMapping{Deny: []string{"x-death"}}, header: "x-death", want: ""},
+		{name: "allowed but denied", mapping: HeaderMapping{Allow: []string{"x-*"}, Deny: []string{"x-death"}}, header: "x-death", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, ok := test.mapping.Name(test.header)
+
+			if test.want == "" && ok {
+				t.Errorf("Header %v should be skipped, got %v", test.header, name)
+			}
+
+			if test.want != "" && name != test.want {
+				t.Errorf("Header name does not match: want %v, got %v", test.want, name)
+			}
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	h := headers(amqp.Delivery{
+		RoutingKey: "foo",
+		Headers:    amqp.Table{"x-tenant": "acme"},
+	}, &HeaderMapping{Prefix: "HTTP_", Normalize: true})
+
+	if h["ROUTING_KEY"] != "foo" {
+		t.Errorf("Header ROUTING_KEY does not match: want %v, got %v", "foo", h["ROUTING_KEY"])
+	}
+
+	if h["HTTP_X_TENANT"] != "acme" {
+		t.Errorf("Header HTTP_X_TENANT does not match: want %v, got %v", "acme", h["HTTP_X_TENANT"])
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "string", value: "foo", want: "foo"},
+		{name: "integer", value: int64(42), want: "42"},
+		{name: "boolean", value: true, want: "true"},
+		{name: "nil", value: nil, want: ""},
+		{name: "bytes", value: []byte("foo"), want: "Zm9v"},
+		{name: "timestamp", value: time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), want: "2018-01-02T03:04:05Z"},
+		{name: "array", value: []interface{}{"foo", int32(1)}, want: `["foo",1]`},
+		{name: "table", value: amqp.Table{"count": int64(2), "queue": "foo"}, want: `{"count":2,"queue":"foo"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := encodeHeader(test.value); got != test.want {
+				t.Errorf("Encoded header value does not match: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestHeaders_JSON(t *testing.T) {
+	h := headers(amqp.Delivery{
+		Headers: amqp.Table{"x-tenant": "acme", "x-secret": "foo"},
+	}, &HeaderMapping{Prefix: "AMQP_", Deny: []string{"x-secret"}})
+
+	if want := `{"x-tenant":"acme"}`; h["AMQP_HEADERS_JSON"] != want {
+		t.Errorf("Header AMQP_HEADERS_JSON does not match: want %v, got %v", want, h["AMQP_HEADERS_JSON"])
+	}
+}
+
+func TestHeaders_JSONPrefix(t *testing.T) {
+	h := headers(amqp.Delivery{Headers: amqp.Table{"x-tenant": "acme"}}, &HeaderMapping{Prefix: "HTTP_"})
+
+	if want := `{"x-tenant":"acme"}`; h["HTTP_HEADERS_JSON"] != want {
+		t.Errorf("Header HTTP_HEADERS_JSON does not match: want %v, got %v", want, h["HTTP_HEADERS_JSON"])
+	}
+}
+
+func TestHeaders_Timestamp(t *testing.T) {
+	h := headers(amqp.Delivery{Timestamp: time.Unix(1514862245, 0)}, nil)
+
+	if want := time.Unix(1514862245, 0).Format(time.RFC3339); h["TIMESTAMP"] != want {
+		t.Errorf("Header TIMESTAMP does not match: want %v, got %v", want, h["TIMESTAMP"])
+	}
+
+	if want := "1514862245"; h["TIMESTAMP_UNIX"] != want {
+		t.Errorf("Header TIMESTAMP_UNIX does not match: want %v, got %v", want, h["TIMESTAMP_UNIX"])
+	}
+}
+
+func TestHeaders_Deaths(t *testing.T) {
+	h := headers(amqp.Delivery{
+		Redelivered: true,
+		Headers: amqp.Table{
+			"x-delivery-count": int64(3),
+			"x-death": []interface{}{
+				amqp.Table{"count": int64(2), "reason": "expired", "queue": "retry", "exchange": "retry"},
+				amqp.Table{"count": int64(1), "reason": "rejected", "queue": "orders", "exchange": "events", "time": time.Unix(1514862245, 0)},
+			},
+		},
+	}, nil)
+
+	want := map[string]string{
+		"DEATH_COUNT":        "3",
+		"DEATH_REASONS":      "expired,rejected",
+		"ORIGINAL_QUEUE":     "orders",
+		"ORIGINAL_EXCHANGE":  "events",
+		"FIRST_DEATH_REASON": "rejected",
+		"FIRST_DEATH_TIME":   time.Unix(1514862245, 0).Format(time.RFC3339),
+		"DELIVERY_COUNT":     "3",
+	}
+
+	for k, v := range want {
+		if h[k] != v {
+			t.Errorf("Header %v does not match: want %v, got %v", k, v, h[k])
+		}
+	}
+}
+
+func TestHeaders_NoDeaths(t *testing.T) {
+	h := headers(amqp.Delivery{}, nil)
+
+	if h["DEATH_COUNT"] != "0" {
+		t.Errorf("Header DEATH_COUNT does not match: want %v, got %v", "0", h["DEATH_COUNT"])
+	}
+
+	if h["DELIVERY_COUNT"] != "0" {
+		t.Errorf("Header DELIVERY_COUNT does not match: want %v, got %v", "0", h["DELIVERY_COUNT"])
+	}
+
+	if _, ok := h["ORIGINAL_QUEUE"]; ok {
+		t.Errorf("Header ORIGINAL_QUEUE should not be set for messages which were never dead-lettered")
+	}
+}
+
+func TestRedeliveries(t *testing.T) {
+	tests := []struct {
+		name string
+		dvs  []amqp.Delivery
+		want int
+	}{
+		{name: "new message", dvs: []amqp.Delivery{{}}, want: 0},
+		{name: "redelivered", dvs: []amqp.Delivery{{Redelivered: true}}, want: 1},
+		{name: "delivery count", dvs: []amqp.Delivery{{Redelivered: true, Headers: amqp.Table{"x-delivery-count": int64(4)}}}, want: 4},
+		{name: "deaths", dvs: []amqp.Delivery{{Headers: amqp.Table{"x-death": []interface{}{amqp.Table{"count": int64(2)}}}}}, want: 2},
+		{name: "batch", dvs: []amqp.Delivery{{}, {Headers: amqp.Table{"x-delivery-count": int64(3)}}, {Redelivered: true}}, want: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := redeliveries(test.dvs...); got != test.want {
+				t.Errorf("Number of re-deliveries does not match: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// with empty prefix headers must not overwrite delivery properties or choose which script runs
+func TestHeaders_NoPrefix(t *testing.T) {
+	h := headers(amqp.Delivery{
+		MessageId:   "42",
+		ContentType: "application/json",
+		Headers: amqp.Table{
+			"script_filename": "/tmp/evil.php",
+			"message_id":      "1",
+			"content_type":    "text/plain",
+			"tenant":          "acme",
+		},
+	}, &HeaderMapping{})
+
+	if v, ok := h["SCRIPT_FILENAME"]; ok {
+		t.Errorf("Header SCRIPT_FILENAME should not be taken from message, got %v", v)
+	}
+
+	want := map[string]string{
+		"MESSAGE_ID":   "42",
+		"CONTENT_TYPE": "application/json",
+		"TENANT":       "acme",
+	}
+
+	for k, v := range want {
+		if h[k] != v {
+			t.Errorf("Header %v does not match: want %v, got %v", k, v, h[k])
+		}
+	}
+}
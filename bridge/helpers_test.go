@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeTempFile writes data to a file in a new temporary directory, which is removed when test finishes
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatalf("Unable to write temporary file: %v", err)
+	}
+
+	return filename
+}
+
+// acknowledger records how deliveries were settled
+type acknowledger struct {
+	mu      sync.Mutex
+	settled []string
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.settle(fmt.Sprintf("ack %v", tag))
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.settle(fmt.Sprintf("nack %v %v", tag, requeue))
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.settle(fmt.Sprintf("reject %v %v", tag, requeue))
+}
+
+func (a *acknowledger) settle(s string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.settled = append(a.settled, s)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+// IdempotencyStore keeps keys of successfully processed messages on disk, keys expire after given TTL
+type IdempotencyStore struct {
+	db      *bbolt.DB
+	wg      sync.WaitGroup
+	stop    chan struct{}
+	log     logger
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+// NewIdempotencyStore opens store database and starts a routine which periodically purges expired keys
+func NewIdempotencyStore(filename string, log logger) (*IdempotencyStore, error) {
+	db, err := bbolt.Open(filename, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &IdempotencyStore{
+		db:      db,
+		stop:    make(chan struct{}),
+		log:     log,
+		pending: make(map[string]chan struct{}),
+	}
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				if err := s.Purge(time.Now()); err != nil {
+					s.log.Errorf("Unable to purge expired idempotency keys: %v", err)
+				}
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// Reserve key in given namespace for processing, it waits while key is reserved by another message. Returned function
+// releases reservation, key should be recorded before reservation is released so waiting messages see it.
+func (s *IdempotencyStore) Reserve(ctx context.Context, namespace, key string) (func(), error) {
+	id := namespace + "\x00" + key
+
+	for {
+		s.mu.Lock()
+		busy, ok := s.pending[id]
+		if !ok {
+			done := make(chan struct{})
+			s.pending[id] = done
+			s.mu.Unlock()
+
+			return func() {
+				s.mu.Lock()
+				delete(s.pending, id)
+				s.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Seen checks if key has been recorded in given namespace and has not expired yet
+func (s *IdempotencyStore) Seen(namespace, key string) (bool, error) {
+	seen := false
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(namespace))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(key))
+		seen = v != nil && !expired(v, time.Now())
+
+		return nil
+	})
+
+	return seen, err
+}
+
+// Record key in given namespace, key expires after ttl
+func (s *IdempotencyStore) Record(namespace, key string, ttl time.Duration) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(namespace))
+		if err != nil {
+			return err
+		}
+
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(time.Now().Add(ttl).UnixNano()))
+
+		return b.Put([]byte(key), v)
+	})
+}
+
+// Purge keys expired by given time
+func (s *IdempotencyStore) Purge(now time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			c := b.Cursor()
+
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if !expired(v, now) {
+					continue
+				}
+
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// Close store database
+func (s *IdempotencyStore) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+
+	return s.db.Close()
+}
+
+func expired(v []byte, now time.Time) bool {
+	return len(v) != 8 || int64(binary.BigEndian.Uint64(v)) < now.UnixNano()
+}
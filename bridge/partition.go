@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"strings"
+	"sync"
+)
+
+// PartitionKey returns partition key of a message, messages with the same key are processed sequentially.
+// Empty key means message can be processed in parallel with any other message.
+type PartitionKey func(d amqp.Delivery) string
+
+// NewPartitionKey creates partition key function: "routing_key" uses message routing key, "header:name" uses value of
+// given AMQP header and "json:path.to.field" uses value of a field in JSON message body
+func NewPartitionKey(key string) (PartitionKey, error) {
+	switch {
+	case key == "routing_key":
+		return func(d amqp.Delivery) string {
+			return d.RoutingKey
+		}, nil
+	case strings.HasPrefix(key, "header:"):
+		name := strings.TrimPrefix(key, "header:")
+
+		return func(d amqp.Delivery) string {
+			return encodeHeader(d.Headers[name])
+		}, nil
+	case strings.HasPrefix(key, "json:"):
+		path := strings.Split(strings.TrimPrefix(key, "json:"), ".")
+
+		return func(d amqp.Delivery) string {
+			var v interface{}
+			if err := json.Unmarshal(d.Body, &v); err != nil {
+				return ""
+			}
+
+			for _, p := range path {
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return ""
+				}
+
+				v = m[p]
+			}
+
+			switch v := v.(type) {
+			case nil, map[string]interface{}, []interface{}:
+				return ""
+			case string:
+				return v
+			default:
+				return fmt.Sprint(v)
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown partition key %q", key)
+	}
+}
+
+// partitions keeps track of messages being processed for every partition key
+type partitions struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{}
+}
+
+func newPartitions() *partitions {
+	return &partitions{tails: make(map[string]chan struct{})}
+}
+
+// next registers message with given partition key. It returns a channel which is closed when previous message with the
+// same key is processed (nil if there is no such message) and a channel to close when this message is processed.
+func (p *partitions) next(key string) (prev chan struct{}, done chan struct{}) {
+	if key == "" {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev = p.tails[key]
+	done = make(chan struct{})
+	p.tails[key] = done
+
+	return prev, done
+}
+
+// release marks message as processed
+func (p *partitions) release(key string, done chan struct{}) {
+	if done == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tails[key] == done {
+		delete(p.tails, key)
+	}
+
+	close(done)
+}
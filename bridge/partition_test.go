@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestNewPartitionKey(t *testing.T) {
+	d := amqp.Delivery{
+		RoutingKey: "order.created",
+		Headers:    amqp.Table{"x-customer": "acme"},
+		Body:       []byte(`{"customer":{"id":42}}`),
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "routing_key", want: "order.created"},
+		{key: "header:x-customer", want: "acme"},
+		{key: "header:x-missing", want: ""},
+		{key: "json:customer.id", want: "42"},
+		{key: "json:customer", want: ""},
+		{key: "json:customer.name", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			key, err := NewPartitionKey(test.key)
+			if err != nil {
+				t.Fatalf("Unable to create partition key: %v", err)
+			}
+
+			if got := key(d); got != test.want {
+				t.Errorf("Partition key does not match: want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	p := newPartitions()
+
+	prev1, done1 := p.next("a")
+	prev2, done2 := p.next("a")
+	prev3, done3 := p.next("b")
+
+	if prev1 != nil || prev3 != nil {
+		t.Fatalf("First message in partition should not wait for other messages")
+	}
+
+	if prev2 != done1 {
+		t.Fatalf("Second message in partition should wait for the first one")
+	}
+
+	p.release("a", done1)
+
+	select {
+	case <-prev2:
+	default:
+		t.Errorf("Second message in partition should be unblocked when first one is processed")
+	}
+
+	p.release("a", done2)
+	p.release("b", done3)
+
+	if len(p.tails) != 0 {
+		t.Errorf("Processed partitions should be released, %v left", len(p.tails))
+	}
+
+	if prev, done := p.next(""); prev != nil || done != nil {
+		t.Errorf("Messages without partition key should not be tracked")
+	}
+}
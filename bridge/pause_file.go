@@ -0,0 +1,18 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+)
+
+// PauseFile is a gate which is closed while given file exists
+type PauseFile string
+
+// Open returns false if pause file exists
+func (f PauseFile) Open() (bool, string) {
+	if _, err := os.Stat(string(f)); err == nil {
+		return false, fmt.Sprintf("pause file %v exists", string(f))
+	}
+
+	return true, ""
+}
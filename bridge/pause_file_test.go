@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPauseFile(t *testing.T) {
+	dir := t.TempDir()
+	f := PauseFile(filepath.Join(dir, "pause"))
+
+	if open, reason := f.Open(); !open {
+		t.Errorf("Gate should be open when pause file does not exist: %v", reason)
+	}
+
+	if err := ioutil.WriteFile(string(f), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if open, _ := f.Open(); open {
+		t.Errorf("Gate should be closed when pause file exists")
+	}
+
+	if err := os.Remove(string(f)); err != nil {
+		t.Fatal(err)
+	}
+
+	if open, reason := f.Open(); !open {
+		t.Errorf("Gate should be open after pause file is removed: %v", reason)
+	}
+}
@@ -14,6 +14,9 @@ func NewExecProcessor(cmd string, args ...string) Processor {
 		c.Stdin = bytes.NewReader(body)
 		c.Stderr = ioutil.Discard
 		c.Stdout = ioutil.Discard
+		if w := responseFromContext(ctx); w != nil {
+			c.Stdout = w
+		}
 		c.Env = make([]string, 0, len(headers))
 
 		for k, v := range headers {
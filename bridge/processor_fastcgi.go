@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/tomasen/fcgi_client"
+	"io"
 )
 
 func NewFastCGIProcessor(net, addr, script string, log logger) Processor {
@@ -27,10 +28,18 @@ func NewFastCGIProcessor(net, addr, script string, log logger) Processor {
 			env["REQUEST_URI"] = "/"
 		}
 
+		// SCRIPT_FILENAME can be set by "params", it's never taken from message headers (see reservedNames)
+		if _, ok := env["SCRIPT_FILENAME"]; !ok {
+			env["SCRIPT_FILENAME"] = script
+		}
+
 		env["CONTENT_LENGTH"] = fmt.Sprint(len(body))
-		env["SCRIPT_FILENAME"] = script
 
 		resp, err := conn.Request(env, bytes.NewReader(append(body, 13, 10, 13, 10)))
+		if w := responseFromContext(ctx); err == nil && w != nil {
+			_, err = io.Copy(w, resp.Body)
+		}
+
 		conn.Close()
 		if err != nil {
 			log.Errorf("An error occurred while making FastCGI request: %v", err)
@@ -57,6 +57,24 @@ func TestFastCGIProcessor_EnvVariables(t *testing.T) {
 	}
 }
 
+func TestFastCGIProcessor_ScriptFilename(t *testing.T) {
+	addr := os.Getenv("TEST_PHPFPM_ADDR")
+	if addr == "" {
+		t.Skip("This test requires PHP-FPM server, use environment variable TEST_PHPFPM_ADDR to set PHP-FPM address.")
+	}
+
+	p := NewFastCGIProcessor("tcp", addr, "/amqp-cgi-bridge/missing.php", &nilLogger{})
+
+	err := p(context.Background(), map[string]string{"TEST": "ACCEPT", "SCRIPT_FILENAME": TestScript}, nil)
+	if err == ErrProcessingError {
+		t.Fatalf("It seems SCRIPT_FILENAME passed in environment variables is overwritten by default script")
+	}
+
+	if err != nil {
+		t.Fatalf("An error occurred while processing request: %v", err)
+	}
+}
+
 func TestFastCGIProcessor_InternalError(t *testing.T) {
 	p := NewFastCGIProcessor("tcp", "0.0.0.0:0", TestScript, &nilLogger{})
 
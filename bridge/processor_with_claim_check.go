@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errClaimCheckTooLarge = errors.New("payload exceeds size limit")
+var errClaimCheckOutside = errors.New("file is outside of base directory")
+
+// ClaimCheckOptions defines how message payloads stored outside of the broker are loaded
+type ClaimCheckOptions struct {
+	// Header is AMQP header which contains reference to the payload, eq. file:///shared/payload.json or /shared/payload.json
+	Header string
+	// BaseDir is a directory payloads can be loaded from, references outside of this directory are rejected
+	BaseDir string
+	// Delete payload after message has been successfully processed and acked
+	Delete bool
+	// MaxSize is maximum size of the payload in bytes, zero means no limit
+	MaxSize int64
+}
+
+// ProcessorWithClaimCheck loads message payload referenced in a header and passes it to the processor as message body,
+// messages without the header are passed as is
+func ProcessorWithClaimCheck(p Processor, opts ClaimCheckOptions, log logger) (Processor, error) {
+	base, err := filepath.Abs(opts.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if base, err = filepath.EvalSymlinks(base); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		d, _ := deliveryFromContext(ctx)
+
+		ref, _ := d.Headers[opts.Header].(string)
+		if ref == "" {
+			return p(ctx, headers, body)
+		}
+
+		filename, err := claimCheckPath(base, ref)
+		if err != nil {
+			log.Errorf("Unable to load claim check %q: %v", ref, err)
+			return &RejectionError{Reason: "invalid claim check"}
+		}
+
+		data, err := readClaimCheck(base, filename, opts.MaxSize)
+		if err != nil {
+			log.Errorf("Unable to load claim check %q: %v", ref, err)
+		}
+
+		switch {
+		case err == nil:
+		case err == errClaimCheckTooLarge:
+			return &RejectionError{Reason: "claim check is too large"}
+		case err == errClaimCheckOutside:
+			return &RejectionError{Reason: "invalid claim check"}
+		case os.IsNotExist(err) && isDir(base):
+			// payload is missing while storage is available
+			return &RejectionError{Reason: "claim check not found"}
+		default:
+			// storage may be temporarily unavailable, so message is re-tried
+			return ErrProcessorInternal
+		}
+
+		if opts.Delete {
+			onAck(ctx, func() {
+				if err := os.Remove(filename); err != nil {
+					log.Errorf("Unable to delete claim check %q: %v", ref, err)
+				}
+			})
+		}
+
+		headers["CONTENT_LENGTH"] = fmt.Sprint(len(data))
+
+		return p(ctx, headers, data)
+	}, nil
+}
+
+// claimCheckPath converts reference into a file name and makes sure it's located within base directory
+func claimCheckPath(base, ref string) (string, error) {
+	filename := ref
+
+	if strings.Contains(ref, "://") {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+
+		if u.Scheme != "file" {
+			return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+
+		filename = u.Path
+	}
+
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(base, filename)
+	}
+
+	filename = filepath.Clean(filename)
+
+	if !isWithin(base, filename) {
+		return "", errClaimCheckOutside
+	}
+
+	return filename, nil
+}
+
+// readClaimCheck reads payload, symlinks are resolved and should point to files within base directory
+func readClaimCheck(base, filename string, limit int64) ([]byte, error) {
+	filename, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isWithin(base, filename) {
+		return nil, errClaimCheckOutside
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if limit <= 0 {
+		return ioutil.ReadAll(f)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errClaimCheckTooLarge
+	}
+
+	return data, nil
+}
+
+// isWithin checks if file name is located within base directory
+func isWithin(base, filename string) bool {
+	rel, err := filepath.Rel(base, filename)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func isDir(name string) bool {
+	fi, err := os.Stat(name)
+
+	return err == nil && fi.IsDir()
+}
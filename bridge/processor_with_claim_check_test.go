@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessorWithClaimCheck(t *testing.T) {
+	filename := writeTempFile(t, "payload.json", []byte("foo"))
+	base := filepath.Dir(filename)
+	outside := writeTempFile(t, "secret", []byte("secret"))
+
+	tests := []struct {
+		name  string
+		ref   interface{}
+		body  string
+		valid bool
+	}{
+		{name: "file url", ref: "file://" + filename, body: "foo", valid: true},
+		{name: "absolute path", ref: filename, body: "foo", valid: true},
+		{name: "relative path", ref: "payload.json", body: "foo", valid: true},
+		{name: "no claim check", ref: nil, body: "stub", valid: true},
+		{name: "missing file", ref: filepath.Join(base, "missing.json"), valid: false},
+		{name: "outside of base directory", ref: outside, valid: false},
+		{name: "parent directory", ref: "../" + filepath.Base(filepath.Dir(outside)) + "/secret", valid: false},
+		{name: "unsupported scheme", ref: "http://localhost/payload.json", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executed := false
+
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				executed = true
+
+				if string(b) != test.body {
+					t.Errorf("Body does not match: want %v, got %s", test.body, b)
+				}
+
+				return nil
+			}
+
+			p, err := ProcessorWithClaimCheck(p, ClaimCheckOptions{Header: "x-claim-check", BaseDir: base}, &nilLogger{})
+			if err != nil {
+				t.Fatalf("Unable to create processor: %v", err)
+			}
+
+			ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-claim-check": test.ref}})
+			err = p(ctx, nil, []byte("stub"))
+
+			if test.valid && (err != nil || !executed) {
+				t.Errorf("Message should be processed, got error %v", err)
+			}
+
+			if _, ok := err.(*RejectionError); !test.valid && (!ok || executed) {
+				t.Errorf("Message should be rejected, got error %v", err)
+			}
+		})
+	}
+}
+
+// ProcessorWithClaimCheck should delete payload only after message has been acked
+func TestProcessorWithClaimCheck_Delete(t *testing.T) {
+	filename := writeTempFile(t, "payload.json", []byte("foo"))
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		return nil
+	}
+
+	p, err := ProcessorWithClaimCheck(p, ClaimCheckOptions{Header: "x-claim-check", BaseDir: filepath.Dir(filename), Delete: true}, &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to create processor: %v", err)
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-claim-check": filename}})
+	if err := p(ctx, nil, nil); err != nil {
+		t.Fatalf("Message should be processed, got error %v", err)
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("Payload should not be deleted before message is acked")
+	}
+
+	acked(ctx)
+
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Payload should be deleted after message is acked")
+	}
+}
+
+// ProcessorWithClaimCheck should re-try message when storage is not available
+func TestProcessorWithClaimCheck_StorageUnavailable(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "storage")
+	if err := os.Mkdir(base, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		return nil
+	}
+
+	p, err := ProcessorWithClaimCheck(p, ClaimCheckOptions{Header: "x-claim-check", BaseDir: base}, &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to create processor: %v", err)
+	}
+
+	if err := os.Remove(base); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-claim-check": "payload.json"}})
+	if err := p(ctx, nil, nil); err != ErrProcessorInternal {
+		t.Errorf("Message should be re-tried when storage is not available, got error %v", err)
+	}
+}
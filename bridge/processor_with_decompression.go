@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// snappyStreamHeader is a magic chunk which starts snappy framing format stream
+var snappyStreamHeader = []byte("\xff\x06\x00\x00sNaPpY")
+
+var errDecompressedSizeLimit = errors.New("decompressed body exceeds size limit")
+
+// ProcessorWithDecompression decompresses message body according to content encoding (gzip, deflate, zstd or snappy).
+// Decompressed body can not be larger than limit bytes, larger messages are rejected to prevent decompression bombs.
+func ProcessorWithDecompression(p Processor, limit int64, log logger) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		// content encodings are listed in order they were applied, so we need to decode them in reverse order
+		encodings := strings.Split(headers["CONTENT_ENCODING"], ",")
+
+		for len(encodings) > 0 {
+			enc := strings.ToLower(strings.TrimSpace(encodings[len(encodings)-1]))
+			if enc == "" || enc == "identity" {
+				encodings = encodings[:len(encodings)-1]
+				continue
+			}
+
+			data, ok, err := decompress(enc, body, limit)
+			if !ok {
+				break
+			}
+
+			if err != nil {
+				log.Errorf("Unable to decompress message body encoded with %v: %v", enc, err)
+				return ErrProcessingError
+			}
+
+			body = data
+			encodings = encodings[:len(encodings)-1]
+		}
+
+		if len(encodings) > 0 {
+			headers["CONTENT_ENCODING"] = strings.Join(encodings, ",")
+		} else {
+			delete(headers, "CONTENT_ENCODING")
+		}
+
+		headers["CONTENT_LENGTH"] = fmt.Sprint(len(body))
+
+		return p(ctx, headers, body)
+	}
+}
+
+// decompress body using given encoding, second value is false if encoding is not supported
+func decompress(enc string, body []byte, limit int64) ([]byte, bool, error) {
+	var r io.Reader
+	var err error
+
+	switch enc {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is supposed to be zlib format, but raw deflate stream is commonly used too
+		r, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "zstd":
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1)); err == nil {
+			defer d.Close()
+			r = d
+		}
+	case "snappy", "x-snappy-framed":
+		if bytes.HasPrefix(body, snappyStreamHeader) {
+			r = snappy.NewReader(bytes.NewReader(body))
+			break
+		}
+
+		n, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, true, err
+		}
+
+		if int64(n) > limit {
+			return nil, true, errDecompressedSizeLimit
+		}
+
+		data, err := snappy.Decode(nil, body)
+		return data, true, err
+	default:
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, true, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, true, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, true, errDecompressedSizeLimit
+	}
+
+	return data, true, nil
+}
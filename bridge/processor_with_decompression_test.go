@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"testing"
+)
+
+func TestProcessorWithDecompression(t *testing.T) {
+	payload := []byte("hello, world! hello, world! hello, world!")
+
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write(payload)
+	w.Close()
+
+	enc, _ := zstd.NewWriter(nil)
+	zs := enc.EncodeAll(payload, nil)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		err      error
+		left     string // expected remaining content encoding
+	}{
+		{name: "identity", encoding: "", body: payload},
+		{name: "gzip", encoding: "gzip", body: gz.Bytes()},
+		{name: "zstd", encoding: "zstd", body: zs},
+		{name: "snappy", encoding: "snappy", body: snappy.Encode(nil, payload)},
+		{name: "unknown", encoding: "br", body: payload, left: "br"},
+		{name: "corrupted", encoding: "gzip", body: payload, err: ErrProcessingError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				if !bytes.Equal(b, payload) {
+					t.Errorf("Body does not match: want %s, got %s", payload, b)
+				}
+
+				if h["CONTENT_ENCODING"] != test.left {
+					t.Errorf("Content encoding does not match: want %v, got %v", test.left, h["CONTENT_ENCODING"])
+				}
+
+				return nil
+			}
+
+			err := ProcessorWithDecompression(p, 1024, &nilLogger{})(context.Background(), map[string]string{"CONTENT_ENCODING": test.encoding}, test.body)
+			if err != test.err {
+				t.Errorf("Processor error does not match: want %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// ProcessorWithDecompression should reject messages which decompress to a body larger than limit
+func TestProcessorWithDecompression_Limit(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write(make([]byte, 1025))
+	w.Close()
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		t.Errorf("Inner processor should not be executed")
+		return nil
+	}
+
+	err := ProcessorWithDecompression(p, 1024, &nilLogger{})(context.Background(), map[string]string{"CONTENT_ENCODING": "gzip"}, gz.Bytes())
+	if err != ErrProcessingError {
+		t.Errorf("Processor error does not match: want %v, got %v", ErrProcessingError, err)
+	}
+}
@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// DecryptionOptions defines how message bodies encrypted using envelope encryption are decrypted
+type DecryptionOptions struct {
+	// KeyIDHeader is AMQP header which contains id of the key-encryption key
+	KeyIDHeader string
+	// DataKeyHeader is AMQP header which contains data key encrypted with key-encryption key
+	DataKeyHeader string
+	// Keys maps key id to a file which contains AES key-encryption key (16, 24 or 32 bytes, base64 encoded or raw),
+	// file content which is a valid base64 string is always decoded
+	Keys map[string]string
+}
+
+// ProcessorWithDecryption decrypts message body encrypted with AES-GCM. Message body is encrypted with a data key, which is
+// encrypted (wrapped) with key-encryption key and passed in a header along with the id of key-encryption key.
+// Both encrypted body and data key are expected to be a 12 bytes nonce followed by the ciphertext.
+func ProcessorWithDecryption(p Processor, opts DecryptionOptions, log logger) (Processor, error) {
+	if len(opts.Keys) == 0 {
+		return nil, errors.New("no key-encryption keys configured")
+	}
+
+	keks := make(map[string]cipher.AEAD, len(opts.Keys))
+
+	for id, filename := range opts.Keys {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := loadAESKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key %v: %v", filename, err)
+		}
+
+		if keks[id], err = newGCM(key); err != nil {
+			return nil, fmt.Errorf("unable to load key %v: %v", filename, err)
+		}
+	}
+
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		d, _ := deliveryFromContext(ctx)
+
+		id, _ := d.Headers[opts.KeyIDHeader].(string)
+		kek, ok := keks[id]
+		if !ok {
+			log.Errorf("Unable to decrypt message: unknown key id %q", id)
+			return &RejectionError{Reason: "unknown encryption key"}
+		}
+
+		wrapped, err := decodeBinaryHeader(d.Headers[opts.DataKeyHeader])
+		if err != nil {
+			log.Errorf("Unable to decrypt message: data key %v", err)
+			return &RejectionError{Reason: "decryption failed"}
+		}
+
+		key, err := openGCM(kek, wrapped)
+		if err != nil {
+			log.Errorf("Unable to decrypt data key (key id %q): %v", id, err)
+			return &RejectionError{Reason: "decryption failed"}
+		}
+
+		dek, err := newGCM(key)
+		if err != nil {
+			log.Errorf("Unable to decrypt message: %v", err)
+			return &RejectionError{Reason: "decryption failed"}
+		}
+
+		plaintext, err := openGCM(dek, body)
+		if err != nil {
+			log.Errorf("Unable to decrypt message: %v", err)
+			return &RejectionError{Reason: "decryption failed"}
+		}
+
+		headers["CONTENT_LENGTH"] = fmt.Sprint(len(plaintext))
+
+		return p(ctx, headers, plaintext)
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// openGCM decrypts data which consists of a nonce followed by the ciphertext
+func openGCM(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// loadAESKey loads base64 encoded or raw AES key. Base64 is tried first, because base64 encoded 16 and 24 bytes keys
+// have the length of raw 24 and 32 bytes keys.
+func loadAESKey(data []byte) ([]byte, error) {
+	if key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err == nil && isAESKeySize(len(key)) {
+		return key, nil
+	}
+
+	if isAESKeySize(len(data)) {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("key should be 16, 24 or 32 bytes long, raw or base64 encoded, got %v bytes", len(data))
+}
+
+func isAESKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// decodeBinaryHeader decodes header value passed as byte array or base64 encoded string
+func decodeBinaryHeader(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return base64.StdEncoding.DecodeString(v)
+	case nil:
+		return nil, errors.New("is missing")
+	default:
+		return nil, fmt.Errorf("has unexpected type %T", v)
+	}
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestProcessorWithDecryption(t *testing.T) {
+	kek := make([]byte, 32)
+	dek := make([]byte, 32)
+	rand.Read(kek)
+	rand.Read(dek)
+
+	seal := func(key, plaintext []byte) []byte {
+		aead, err := newGCM(key)
+		if err != nil {
+			t.Fatalf("Unable to create cipher: %v", err)
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		rand.Read(nonce)
+
+		return aead.Seal(nonce, nonce, plaintext, nil)
+	}
+
+	opts := DecryptionOptions{
+		KeyIDHeader:   "x-encryption-key-id",
+		DataKeyHeader: "x-encrypted-key",
+		Keys:          map[string]string{"1": writeTempFile(t, "kek", kek)},
+	}
+
+	tests := []struct {
+		name    string
+		keyID   string
+		dataKey []byte
+		body    []byte
+		valid   bool
+	}{
+		{name: "valid", keyID: "1", dataKey: seal(kek, dek), body: seal(dek, []byte("foo")), valid: true},
+		{name: "unknown key", keyID: "2", dataKey: seal(kek, dek), body: seal(dek, []byte("foo")), valid: false},
+		{name: "wrong data key", keyID: "1", dataKey: seal(kek, kek), body: seal(dek, []byte("foo")), valid: false},
+		{name: "corrupted body", keyID: "1", dataKey: seal(kek, dek), body: []byte("foo"), valid: false},
+		{name: "missing data key", keyID: "1", body: seal(dek, []byte("foo")), valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executed := false
+
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				executed = true
+
+				if string(b) != "foo" {
+					t.Errorf("Decrypted body does not match: want %v, got %s", "foo", b)
+				}
+
+				return nil
+			}
+
+			p, err := ProcessorWithDecryption(p, opts, &nilLogger{})
+			if err != nil {
+				t.Fatalf("Unable to create processor: %v", err)
+			}
+
+			h := amqp.Table{"x-encryption-key-id": test.keyID}
+			if test.dataKey != nil {
+				h["x-encrypted-key"] = test.dataKey
+			}
+
+			err = p(withDelivery(context.Background(), amqp.Delivery{Headers: h}), nil, test.body)
+
+			if test.valid && (err != nil || !executed) {
+				t.Errorf("Encrypted message should be decrypted and processed, got error %v", err)
+			}
+
+			if _, ok := err.(*RejectionError); !test.valid && (!ok || executed) {
+				t.Errorf("Message which can not be decrypted should be rejected, got error %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadAESKey(t *testing.T) {
+	key16 := bytes.Repeat([]byte{0xfe}, 16)
+	key24 := bytes.Repeat([]byte{0xfe}, 24)
+	key32 := bytes.Repeat([]byte{0xfe}, 32)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{name: "raw 16 bytes", data: key16, want: key16},
+		{name: "raw 32 bytes", data: key32, want: key32},
+		{name: "base64 16 bytes", data: []byte(base64.StdEncoding.EncodeToString(key16)), want: key16},
+		{name: "base64 24 bytes", data: []byte(base64.StdEncoding.EncodeToString(key24)), want: key24},
+		{name: "base64 32 bytes with newline", data: []byte(base64.StdEncoding.EncodeToString(key32) + "\n"), want: key32},
+		{name: "invalid length", data: []byte("foo"), want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := loadAESKey(test.data)
+			if test.want == nil && err == nil {
+				t.Errorf("Key of invalid length should cause an error")
+			}
+
+			if test.want != nil && !bytes.Equal(key, test.want) {
+				t.Errorf("Key does not match: want %x, got %x (%v)", test.want, key, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeduplicationKey returns idempotency key for a message, empty key means message can not be deduplicated
+type DeduplicationKey func(ctx context.Context, headers map[string]string, body []byte) string
+
+// NewDeduplicationKey creates deduplication key function: "message_id" uses message id property, "body" uses SHA-256
+// hash of message body, and "header:name" uses value of given AMQP header
+func NewDeduplicationKey(key string) (DeduplicationKey, error) {
+	switch {
+	case key == "" || key == "message_id":
+		return func(ctx context.Context, headers map[string]string, body []byte) string {
+			return headers["MESSAGE_ID"]
+		}, nil
+	case key == "body":
+		return func(ctx context.Context, headers map[string]string, body []byte) string {
+			sum := sha256.Sum256(body)
+			return hex.EncodeToString(sum[:])
+		}, nil
+	case strings.HasPrefix(key, "header:"):
+		name := strings.TrimPrefix(key, "header:")
+
+		// headers passed to processor depend on header mapping, that's why header is taken from original delivery
+		return func(ctx context.Context, headers map[string]string, body []byte) string {
+			d, _ := deliveryFromContext(ctx)
+			return encodeHeader(d.Headers[name])
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown deduplication key %q", key)
+	}
+}
+
+// ProcessorWithDeduplication skips messages which have already been successfully processed, such messages are acked
+// without being passed to the processor. Message key is recorded only after processor has returned no error, messages
+// with the same key are processed one at a time.
+func ProcessorWithDeduplication(p Processor, store *IdempotencyStore, namespace string, key DeduplicationKey, ttl time.Duration, log logger) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		k := key(ctx, headers, body)
+		if k == "" {
+			return p(ctx, headers, body)
+		}
+
+		// reservation is only interrupted when consumer is stopping, message is then returned to the queue
+		release, err := store.Reserve(ctx, namespace, k)
+		if err != nil {
+			return ErrProcessingInterrupted
+		}
+
+		defer release()
+
+		seen, err := store.Seen(namespace, k)
+		if err != nil {
+			log.Errorf("Unable to check idempotency key %q: %v", k, err)
+		}
+
+		if seen {
+			log.Debugf("Message with idempotency key %q has already been processed, skipping", k)
+			return nil
+		}
+
+		if err := p(ctx, headers, body); err != nil {
+			return err
+		}
+
+		if err := store.Record(namespace, k, ttl); err != nil {
+			log.Errorf("Unable to record idempotency key %q: %v", k, err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProcessorWithDeduplication(t *testing.T) {
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "store.db"), &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to open idempotency store: %v", err)
+	}
+
+	defer store.Close()
+
+	key, err := NewDeduplicationKey("message_id")
+	if err != nil {
+		t.Fatalf("Unable to create deduplication key: %v", err)
+	}
+
+	calls := 0
+	fail := true
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		calls++
+
+		if fail {
+			return errors.New("failed")
+		}
+
+		return nil
+	}
+
+	p = ProcessorWithDeduplication(p, store, "queue", key, time.Hour, &nilLogger{})
+	h := map[string]string{"MESSAGE_ID": "1"}
+
+	// failed message should not be recorded
+	p(context.Background(), h, nil)
+
+	fail = false
+
+	if err := p(context.Background(), h, nil); err != nil {
+		t.Fatalf("Message should be processed, got error %v", err)
+	}
+
+	if err := p(context.Background(), h, nil); err != nil {
+		t.Fatalf("Duplicate message should be acked, got error %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("Processor should be executed until message is successfully processed once, executed %v times", calls)
+	}
+
+	// messages without key can not be deduplicated
+	p(context.Background(), map[string]string{}, nil)
+	p(context.Background(), map[string]string{}, nil)
+
+	if calls != 4 {
+		t.Errorf("Messages without idempotency key should always be processed, executed %v times", calls-2)
+	}
+}
+
+// ProcessorWithDeduplication should process only one of the duplicates delivered at the same time
+func TestProcessorWithDeduplication_Concurrent(t *testing.T) {
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "store.db"), &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to open idempotency store: %v", err)
+	}
+
+	defer store.Close()
+
+	key, err := NewDeduplicationKey("message_id")
+	if err != nil {
+		t.Fatalf("Unable to create deduplication key: %v", err)
+	}
+
+	var calls int32
+	started := make(chan struct{}, 2)
+	finish := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-finish
+
+		return nil
+	}
+
+	p = ProcessorWithDeduplication(p, store, "queue", key, time.Hour, &nilLogger{})
+	h := map[string]string{"MESSAGE_ID": "1"}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			p(context.Background(), h, nil)
+		}()
+	}
+
+	// give the second duplicate time to reach processor, if it's not held back
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	close(finish)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Duplicates delivered at the same time should be processed once, executed %v times", n)
+	}
+}
+
+func TestProcessorWithDeduplication_Interrupted(t *testing.T) {
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "store.db"), &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to open idempotency store: %v", err)
+	}
+
+	defer store.Close()
+
+	key, err := NewDeduplicationKey("message_id")
+	if err != nil {
+		t.Fatalf("Unable to create deduplication key: %v", err)
+	}
+
+	// key is reserved by a message in processing
+	release, err := store.Reserve(context.Background(), "queue", "1")
+	if err != nil {
+		t.Fatalf("Unable to reserve idempotency key: %v", err)
+	}
+
+	defer release()
+
+	p := ProcessorWithDeduplication(func(c context.Context, h map[string]string, b []byte) error {
+		return nil
+	}, store, "queue", key, time.Hour, &nilLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := p(ctx, map[string]string{"MESSAGE_ID": "1"}, nil); err != ErrProcessingInterrupted {
+		t.Errorf("Waiting for reserved key should be interrupted when consumer is stopping, got %v", err)
+	}
+}
+
+func TestIdempotencyStore_Expire(t *testing.T) {
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "store.db"), &nilLogger{})
+	if err != nil {
+		t.Fatalf("Unable to open idempotency store: %v", err)
+	}
+
+	defer store.Close()
+
+	store.Record("queue", "1", -time.Second)
+	store.Record("queue", "2", time.Hour)
+
+	if seen, _ := store.Seen("queue", "1"); seen {
+		t.Errorf("Expired key should not be seen")
+	}
+
+	if seen, _ := store.Seen("other", "2"); seen {
+		t.Errorf("Key should not be seen in other namespace")
+	}
+
+	if err := store.Purge(time.Now()); err != nil {
+		t.Fatalf("Unable to purge expired keys: %v", err)
+	}
+
+	if seen, _ := store.Seen("queue", "2"); !seen {
+		t.Errorf("Key should be seen until it expires")
+	}
+}
+
+func TestNewDeduplicationKey_Header(t *testing.T) {
+	key, err := NewDeduplicationKey("header:x-idempotency-key")
+	if err != nil {
+		t.Fatalf("Unable to create deduplication key: %v", err)
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-idempotency-key": "foo"}})
+
+	if k := key(ctx, nil, nil); k != "foo" {
+		t.Errorf("Deduplication key does not match: want %v, got %v", "foo", k)
+	}
+}
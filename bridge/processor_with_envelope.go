@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"mime"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type envelopeProperties struct {
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationID   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageID       string     `json:"message_id,omitempty"`
+	Timestamp       *time.Time `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserID          string     `json:"user_id,omitempty"`
+	AppID           string     `json:"app_id,omitempty"`
+}
+
+type envelope struct {
+	Exchange     string             `json:"exchange"`
+	RoutingKey   string             `json:"routing_key"`
+	Redelivered  bool               `json:"redelivered"`
+	Properties   envelopeProperties `json:"properties"`
+	Headers      amqp.Table         `json:"headers"`
+	Body         string             `json:"body"`
+	BodyEncoding string             `json:"body_encoding"`
+}
+
+// ProcessorWithEnvelope wraps AMQP delivery into a JSON document which contains message properties, headers and body.
+// Body is passed as a string for textual content types and base64 encoded otherwise. Headers are filtered according to
+// header mapping.
+func ProcessorWithEnvelope(p Processor, m *HeaderMapping) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		d, _ := deliveryFromContext(ctx)
+
+		e := envelope{
+			Exchange:    d.Exchange,
+			RoutingKey:  d.RoutingKey,
+			Redelivered: d.Redelivered,
+			Properties: envelopeProperties{
+				ContentType:     headers["CONTENT_TYPE"],
+				ContentEncoding: headers["CONTENT_ENCODING"],
+				DeliveryMode:    d.DeliveryMode,
+				Priority:        d.Priority,
+				CorrelationID:   d.CorrelationId,
+				ReplyTo:         d.ReplyTo,
+				Expiration:      d.Expiration,
+				MessageID:       d.MessageId,
+				Type:            d.Type,
+				UserID:          d.UserId,
+				AppID:           d.AppId,
+			},
+			Headers: filterHeaders(d.Headers, m),
+		}
+
+		if !d.Timestamp.IsZero() {
+			e.Properties.Timestamp = &d.Timestamp
+		}
+
+		if isText(headers["CONTENT_TYPE"]) && utf8.Valid(body) {
+			e.Body = string(body)
+			e.BodyEncoding = "text"
+		} else {
+			e.Body = base64.StdEncoding.EncodeToString(body)
+			e.BodyEncoding = "base64"
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		headers["CONTENT_TYPE"] = "application/json"
+		delete(headers, "CONTENT_ENCODING")
+
+		return p(ctx, headers, data)
+	}
+}
+
+// isText checks if content type represents textual data
+func isText(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(t, "text/") {
+		return true
+	}
+
+	return strings.HasSuffix(t, "/json") ||
+		strings.HasSuffix(t, "+json") ||
+		strings.HasSuffix(t, "/xml") ||
+		strings.HasSuffix(t, "+xml") ||
+		t == "application/javascript" ||
+		t == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+// ProcessorWithEnvelope should wrap textual body into JSON document
+func TestProcessorWithEnvelope_Text(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		var e envelope
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatalf("Body is not a valid JSON: %v", err)
+		}
+
+		if h["CONTENT_TYPE"] != "application/json" {
+			t.Errorf("Content type should be application/json, got %v", h["CONTENT_TYPE"])
+		}
+
+		if e.Body != `{"foo":"bar"}` || e.BodyEncoding != "text" {
+			t.Errorf("Body is not wrapped as text, got %v encoded as %v", e.Body, e.BodyEncoding)
+		}
+
+		if e.RoutingKey != "foo" || e.Properties.MessageID != "1" || e.Headers["x-tenant"] != "acme" {
+			t.Errorf("Message properties are not wrapped correctly: %+v", e)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{
+		RoutingKey: "foo",
+		MessageId:  "1",
+		Headers:    amqp.Table{"x-tenant": "acme"},
+	})
+
+	ProcessorWithEnvelope(p, nil)(ctx, map[string]string{"CONTENT_TYPE": "application/json; charset=utf-8"}, []byte(`{"foo":"bar"}`))
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
+
+// ProcessorWithEnvelope should encode binary body as base64
+func TestProcessorWithEnvelope_Binary(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		var e envelope
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatalf("Body is not a valid JSON: %v", err)
+		}
+
+		if e.Body != "AAEC" || e.BodyEncoding != "base64" {
+			t.Errorf("Body is not wrapped as base64, got %v encoded as %v", e.Body, e.BodyEncoding)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	ProcessorWithEnvelope(p, nil)(context.Background(), map[string]string{"CONTENT_TYPE": "application/octet-stream"}, []byte{0, 1, 2})
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
+
+// ProcessorWithEnvelope should skip headers denied by header mapping
+func TestProcessorWithEnvelope_HeaderMapping(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		var e envelope
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatalf("Body is not a valid JSON: %v", err)
+		}
+
+		if _, ok := e.Headers["x-secret"]; ok || e.Headers["x-tenant"] != "acme" {
+			t.Errorf("Headers are not filtered according to header mapping: %v", e.Headers)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{
+		Headers: amqp.Table{"x-tenant": "acme", "x-secret": "foo"},
+	})
+
+	ProcessorWithEnvelope(p, &HeaderMapping{Deny: []string{"x-secret"}})(ctx, nil, nil)
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
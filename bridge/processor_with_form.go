@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// ProcessorWithMultipart encodes message as multipart/form-data request, so PHP populates $_POST and $_FILES.
+// Message body is passed as a file or as a regular field with given name, AMQP headers allowed by header mapping are
+// passed as headers[name] fields.
+func ProcessorWithMultipart(p Processor, field string, file bool, m *HeaderMapping) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		buf := &bytes.Buffer{}
+		w := multipart.NewWriter(buf)
+
+		d, _ := deliveryFromContext(ctx)
+
+		for k, v := range filterHeaders(d.Headers, m) {
+			if err := w.WriteField(fmt.Sprintf("headers[%v]", k), encodeHeader(v)); err != nil {
+				return err
+			}
+		}
+
+		if file {
+			filename := d.MessageId
+			if filename == "" {
+				filename = "message"
+			}
+
+			contentType := headers["CONTENT_TYPE"]
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%v"; filename="%v"`, quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+			h.Set("Content-Type", contentType)
+
+			part, err := w.CreatePart(h)
+			if err != nil {
+				return err
+			}
+
+			if _, err := part.Write(body); err != nil {
+				return err
+			}
+		} else {
+			if err := w.WriteField(field, string(body)); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		headers["CONTENT_TYPE"] = w.FormDataContentType()
+		delete(headers, "CONTENT_ENCODING")
+
+		return p(ctx, headers, buf.Bytes())
+	}
+}
+
+// ProcessorWithForm encodes message as application/x-www-form-urlencoded request, so PHP populates $_POST.
+// Message body is passed as a field with given name, AMQP headers allowed by header mapping are passed as headers[name]
+// fields.
+func ProcessorWithForm(p Processor, field string, m *HeaderMapping) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		form := url.Values{}
+		form.Set(field, string(body))
+
+		d, _ := deliveryFromContext(ctx)
+
+		for k, v := range filterHeaders(d.Headers, m) {
+			form.Set(fmt.Sprintf("headers[%v]", k), encodeHeader(v))
+		}
+
+		headers["CONTENT_TYPE"] = "application/x-www-form-urlencoded"
+		delete(headers, "CONTENT_ENCODING")
+
+		return p(ctx, headers, []byte(form.Encode()))
+	}
+}
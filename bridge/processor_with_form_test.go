@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"testing"
+)
+
+// ProcessorWithMultipart should pass message body as a file
+func TestProcessorWithMultipart_File(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		_, params, err := mime.ParseMediaType(h["CONTENT_TYPE"])
+		if err != nil {
+			t.Fatalf("Content type is not valid: %v", err)
+		}
+
+		form, err := multipart.NewReader(bytes.NewReader(b), params["boundary"]).ReadForm(1024)
+		if err != nil {
+			t.Fatalf("Body is not a valid multipart form: %v", err)
+		}
+
+		if x := form.Value["headers[x-tenant]"]; len(x) != 1 || x[0] != "acme" {
+			t.Errorf("Headers are not passed as form fields, got %v", x)
+		}
+
+		if _, ok := form.Value["headers[x-secret]"]; ok {
+			t.Errorf("Headers denied by header mapping should not be passed as form fields")
+		}
+
+		files := form.File["message"]
+		if len(files) != 1 {
+			t.Fatalf("Body is not passed as a file")
+		}
+
+		if files[0].Filename != "42" || files[0].Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("File name or content type does not match, got %v and %v", files[0].Filename, files[0].Header.Get("Content-Type"))
+		}
+
+		f, _ := files[0].Open()
+		content, _ := ioutil.ReadAll(f)
+
+		if string(content) != "foo" {
+			t.Errorf("File content does not match: want %v, got %s", "foo", content)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{
+		MessageId: "42",
+		Headers:   amqp.Table{"x-tenant": "acme", "x-secret": "foo"},
+	})
+
+	ProcessorWithMultipart(p, "message", true, &HeaderMapping{Deny: []string{"x-secret"}})(ctx, map[string]string{"CONTENT_TYPE": "text/plain"}, []byte("foo"))
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
+
+// ProcessorWithForm should pass message body and headers as form fields
+func TestProcessorWithForm(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		if h["CONTENT_TYPE"] != "application/x-www-form-urlencoded" {
+			t.Errorf("Content type should be application/x-www-form-urlencoded, got %v", h["CONTENT_TYPE"])
+		}
+
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			t.Fatalf("Body is not a valid form: %v", err)
+		}
+
+		if form.Get("message") != "foo" || form.Get("headers[x-tenant]") != "acme" || form.Get("headers[x-secret]") != "" {
+			t.Errorf("Form fields do not match, got %v", form)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-tenant": "acme", "x-secret": "foo"}})
+
+	ProcessorWithForm(p, "message", &HeaderMapping{Deny: []string{"x-secret"}})(ctx, nil, []byte("foo"))
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
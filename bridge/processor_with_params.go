@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+)
+
+// ProcessorWithParams sets additional request parameters rendered from templates. Templates are evaluated against
+// AMQP delivery, for example "/queue/{{.RoutingKey}}" or "{{index .Headers \"x-tenant\"}}".
+func ProcessorWithParams(p Processor, params map[string]string, log logger) (Processor, error) {
+	tpls := make(map[string]*template.Template, len(params))
+
+	for k, v := range params {
+		tpl, err := template.New(k).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse template for parameter %v: %v", k, err)
+		}
+
+		tpls[k] = tpl
+	}
+
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		d, _ := deliveryFromContext(ctx)
+		buf := &bytes.Buffer{}
+
+		for k, tpl := range tpls {
+			buf.Reset()
+
+			if err := tpl.Execute(buf, d); err != nil {
+				log.Errorf("Unable to render parameter %v: %v", k, err)
+				return ErrProcessingError
+			}
+
+			headers[k] = buf.String()
+		}
+
+		return p(ctx, headers, body)
+	}, nil
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+// ProcessorWithParams should render parameters using AMQP delivery
+func TestProcessorWithParams_Render(t *testing.T) {
+	done := make(chan struct{})
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		if x := h["REQUEST_URI"]; x != "/queue/order.created" {
+			t.Errorf("Parameter REQUEST_URI is not rendered correctly, got %q", x)
+		}
+
+		if x := h["SERVER_NAME"]; x != "acme" {
+			t.Errorf("Parameter SERVER_NAME is not rendered correctly, got %q", x)
+		}
+
+		close(done)
+
+		return nil
+	}
+
+	p, err := ProcessorWithParams(p, map[string]string{
+		"REQUEST_URI": "/queue/{{.RoutingKey}}",
+		"SERVER_NAME": `{{index .Headers "x-tenant"}}`,
+	}, &nilLogger{})
+
+	if err != nil {
+		t.Fatalf("Unable to create processor: %v", err)
+	}
+
+	ctx := withDelivery(context.Background(), amqp.Delivery{
+		RoutingKey: "order.created",
+		Headers:    amqp.Table{"x-tenant": "acme"},
+	})
+
+	p(ctx, nil, nil)
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Inner processor was not executed")
+	}
+}
+
+// ProcessorWithParams should fail to construct when template is invalid
+func TestProcessorWithParams_InvalidTemplate(t *testing.T) {
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		return nil
+	}
+
+	if _, err := ProcessorWithParams(p, map[string]string{"REQUEST_URI": "{{.RoutingKey"}, &nilLogger{}); err == nil {
+		t.Errorf("Invalid template should cause an error")
+	}
+}
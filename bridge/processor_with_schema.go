@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"path"
+)
+
+// SchemaRule defines JSON schema for messages with matching routing key, empty routing key matches any message
+type SchemaRule struct {
+	RoutingKey string
+	Schema     string
+}
+
+// ProcessorWithSchema validates message body against JSON schema of the first matching rule. Invalid messages are
+// rejected with validation errors attached in x-validation-errors header, processor is not executed.
+func ProcessorWithSchema(p Processor, rules []SchemaRule, log logger) (Processor, error) {
+	schemas := make([]*jsonschema.Schema, len(rules))
+
+	for i, r := range rules {
+		s, err := jsonschema.Compile(r.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compile JSON schema %v: %v", r.Schema, err)
+		}
+
+		schemas[i] = s
+	}
+
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		for i, r := range rules {
+			if ok, _ := path.Match(r.RoutingKey, headers["ROUTING_KEY"]); r.RoutingKey != "" && !ok {
+				continue
+			}
+
+			errs := validate(schemas[i], body)
+			if len(errs) == 0 {
+				break
+			}
+
+			log.Errorf("Message does not match JSON schema %v: %v", r.Schema, errs)
+
+			verrs := make([]interface{}, len(errs))
+			for i, err := range errs {
+				verrs[i] = err
+			}
+
+			return &RejectionError{
+				Reason:  "schema validation failed",
+				Headers: map[string]interface{}{"x-validation-errors": verrs},
+			}
+		}
+
+		return p(ctx, headers, body)
+	}, nil
+}
+
+// validate body against JSON schema and return list of validation errors
+func validate(s *jsonschema.Schema, body []byte) []string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	err := s.Validate(v)
+	if err == nil {
+		return nil
+	}
+
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	var errs []string
+	var collect func(*jsonschema.ValidationError)
+
+	collect = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			errs = append(errs, fmt.Sprintf("%v: %v", e.InstanceLocation, e.Message))
+		}
+
+		for _, c := range e.Causes {
+			collect(c)
+		}
+	}
+
+	collect(verr)
+
+	return errs
+}
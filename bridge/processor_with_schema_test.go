@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProcessorWithSchema(t *testing.T) {
+	schema := writeTempFile(t, "schema.json", []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"integer"}}}`))
+
+	tests := []struct {
+		name       string
+		routingKey string
+		body       string
+		valid      bool
+	}{
+		{name: "valid", routingKey: "order.created", body: `{"id":1}`, valid: true},
+		{name: "missing property", routingKey: "order.created", body: `{}`, valid: false},
+		{name: "invalid type", routingKey: "order.created", body: `{"id":"1"}`, valid: false},
+		{name: "invalid JSON", routingKey: "order.created", body: `{`, valid: false},
+		{name: "not matching rule", routingKey: "user.created", body: `{}`, valid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executed := false
+
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				executed = true
+				return nil
+			}
+
+			p, err := ProcessorWithSchema(p, []SchemaRule{{RoutingKey: "order.*", Schema: schema}}, &nilLogger{})
+			if err != nil {
+				t.Fatalf("Unable to create processor: %v", err)
+			}
+
+			err = p(context.Background(), map[string]string{"ROUTING_KEY": test.routingKey}, []byte(test.body))
+
+			if test.valid && (err != nil || !executed) {
+				t.Errorf("Valid message should be processed, got error %v", err)
+			}
+
+			if !test.valid {
+				rej, ok := err.(*RejectionError)
+				if !ok {
+					t.Fatalf("Invalid message should be rejected, got error %v", err)
+				}
+
+				if errs, _ := rej.Headers["x-validation-errors"].([]interface{}); len(errs) == 0 {
+					t.Errorf("Validation errors should be attached to rejected message")
+				}
+
+				if executed {
+					t.Errorf("Inner processor should not be executed for invalid message")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+)
+
+// SignatureOptions defines how message signatures are verified
+type SignatureOptions struct {
+	// Algorithm is one of "hmac-sha256", "hmac-sha512" or "ed25519"
+	Algorithm string
+	// Header is AMQP header which contains hex or base64 encoded signature
+	Header string
+	// KeyIDHeader is AMQP header which contains id of the key used to sign message
+	KeyIDHeader string
+	// Keys maps key id to a file which contains HMAC secret or Ed25519 public key
+	Keys map[string]string
+	// Properties is a list of message properties (eq. MESSAGE_ID or TIMESTAMP_UNIX) signed along with the body,
+	// signed content is value of every property followed by a new line and then message body
+	Properties []string
+}
+
+type verifier func(key interface{}, content, signature []byte) bool
+
+// ProcessorWithSignature verifies message signature before passing it to the processor, messages with missing or
+// invalid signature are rejected
+func ProcessorWithSignature(p Processor, opts SignatureOptions, log logger) (Processor, error) {
+	var verify verifier
+	var load func([]byte) (interface{}, error)
+
+	switch opts.Algorithm {
+	case "hmac-sha256":
+		verify, load = hmacVerifier(sha256.New), loadSecret
+	case "hmac-sha512":
+		verify, load = hmacVerifier(sha512.New), loadSecret
+	case "ed25519":
+		verify, load = ed25519Verifier, loadEd25519Key
+	default:
+		return nil, fmt.Errorf("unknown signature algorithm %q", opts.Algorithm)
+	}
+
+	if len(opts.Keys) == 0 {
+		return nil, errors.New("no signature verification keys configured")
+	}
+
+	keys := make(map[string]interface{}, len(opts.Keys))
+
+	for id, filename := range opts.Keys {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if keys[id], err = load(data); err != nil {
+			return nil, fmt.Errorf("unable to load key %v: %v", filename, err)
+		}
+	}
+
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		d, _ := deliveryFromContext(ctx)
+
+		id, _ := d.Headers[opts.KeyIDHeader].(string)
+		key, ok := keys[id]
+		if !ok && id == "" && len(keys) == 1 {
+			for _, key = range keys {
+				ok = true
+			}
+		}
+
+		if !ok {
+			log.Errorf("Unable to verify message signature: unknown key id %q", id)
+			return &RejectionError{Reason: "unknown signature key"}
+		}
+
+		signature, err := decodeSignature(d.Headers[opts.Header])
+		if err != nil {
+			log.Errorf("Unable to verify message signature: %v", err)
+			return &RejectionError{Reason: "invalid signature"}
+		}
+
+		content := &bytes.Buffer{}
+		for _, prop := range opts.Properties {
+			content.WriteString(headers[prop])
+			content.WriteByte('\n')
+		}
+
+		content.Write(body)
+
+		if !verify(key, content.Bytes(), signature) {
+			log.Errorf("Message signature does not match (key id %q)", id)
+			return &RejectionError{Reason: "invalid signature"}
+		}
+
+		return p(ctx, headers, body)
+	}, nil
+}
+
+func hmacVerifier(h func() hash.Hash) verifier {
+	return func(key interface{}, content, signature []byte) bool {
+		mac := hmac.New(h, key.([]byte))
+		mac.Write(content)
+
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+}
+
+func ed25519Verifier(key interface{}, content, signature []byte) bool {
+	return ed25519.Verify(key.(ed25519.PublicKey), content, signature)
+}
+
+// loadSecret loads HMAC secret, trailing new line is trimmed
+func loadSecret(data []byte) (interface{}, error) {
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	return data, nil
+}
+
+// loadEd25519Key loads Ed25519 public key in PEM format or raw 32 bytes
+func loadEd25519Key(data []byte) (interface{}, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if key, ok := key.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+
+		return nil, errors.New("key is not an Ed25519 public key")
+	}
+
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("key is not an Ed25519 public key")
+	}
+
+	return ed25519.PublicKey(data), nil
+}
+
+// decodeSignature decodes hex or base64 encoded signature
+func decodeSignature(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		if s, err := hex.DecodeString(v); err == nil {
+			return s, nil
+		}
+
+		if s, err := base64.StdEncoding.DecodeString(v); err == nil {
+			return s, nil
+		}
+
+		return nil, errors.New("signature is neither hex nor base64 encoded")
+	case nil:
+		return nil, errors.New("signature is missing")
+	default:
+		return nil, fmt.Errorf("signature has unexpected type %T", v)
+	}
+}
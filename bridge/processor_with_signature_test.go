@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestProcessorWithSignature_HMAC(t *testing.T) {
+	keys := map[string]string{
+		"old": writeTempFile(t, "old.key", []byte("old secret\n")),
+		"new": writeTempFile(t, "new.key", []byte("new secret\n")),
+	}
+
+	sign := func(secret, content string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(content))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name      string
+		keyID     string
+		signature string
+		valid     bool
+	}{
+		{name: "valid", keyID: "new", signature: sign("new secret", "42\nfoo"), valid: true},
+		{name: "rotated key", keyID: "old", signature: sign("old secret", "42\nfoo"), valid: true},
+		{name: "wrong key", keyID: "old", signature: sign("new secret", "42\nfoo"), valid: false},
+		{name: "unknown key", keyID: "foo", signature: sign("new secret", "42\nfoo"), valid: false},
+		{name: "property not signed", keyID: "new", signature: sign("new secret", "foo"), valid: false},
+		{name: "missing signature", keyID: "new", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executed := false
+
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				executed = true
+				return nil
+			}
+
+			p, err := ProcessorWithSignature(p, SignatureOptions{
+				Algorithm:   "hmac-sha256",
+				Header:      "x-signature",
+				KeyIDHeader: "x-key-id",
+				Keys:        keys,
+				Properties:  []string{"MESSAGE_ID"},
+			}, &nilLogger{})
+
+			if err != nil {
+				t.Fatalf("Unable to create processor: %v", err)
+			}
+
+			h := amqp.Table{"x-key-id": test.keyID}
+			if test.signature != "" {
+				h["x-signature"] = test.signature
+			}
+
+			ctx := withDelivery(context.Background(), amqp.Delivery{Headers: h})
+			err = p(ctx, map[string]string{"MESSAGE_ID": "42"}, []byte("foo"))
+
+			if test.valid && (err != nil || !executed) {
+				t.Errorf("Message with valid signature should be processed, got error %v", err)
+			}
+
+			if _, ok := err.(*RejectionError); !test.valid && (!ok || executed) {
+				t.Errorf("Message with invalid signature should be rejected, got error %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessorWithSignature_Ed25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+
+	executed := false
+
+	p := func(c context.Context, h map[string]string, b []byte) error {
+		executed = true
+		return nil
+	}
+
+	p, err = ProcessorWithSignature(p, SignatureOptions{
+		Algorithm: "ed25519",
+		Header:    "x-signature",
+		Keys:      map[string]string{"": writeTempFile(t, "public.key", pub)},
+	}, &nilLogger{})
+
+	if err != nil {
+		t.Fatalf("Unable to create processor: %v", err)
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("foo")))
+	ctx := withDelivery(context.Background(), amqp.Delivery{Headers: amqp.Table{"x-signature": signature}})
+
+	if err := p(ctx, nil, []byte("foo")); err != nil || !executed {
+		t.Errorf("Message with valid signature should be processed, got error %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io/ioutil"
+	"mime"
+)
+
+// Transcoder converts message body into JSON
+type Transcoder func(headers map[string]string, body []byte) ([]byte, error)
+
+// TranscodingRule selects transcoder by message content type and/or type, empty values match any message
+type TranscodingRule struct {
+	ContentType string
+	Type        string
+	Transcoder  Transcoder
+}
+
+// match checks if rule applies to a message with given headers
+func (r TranscodingRule) match(headers map[string]string) bool {
+	if r.ContentType != "" {
+		t, _, err := mime.ParseMediaType(headers["CONTENT_TYPE"])
+		if err != nil || t != r.ContentType {
+			return false
+		}
+	}
+
+	if r.Type != "" && headers["TYPE"] != r.Type {
+		return false
+	}
+
+	return true
+}
+
+// ProcessorWithTranscoding converts message body into JSON using first matching transcoding rule. Messages which do not
+// match any rule are passed as is.
+func ProcessorWithTranscoding(p Processor, rules []TranscodingRule, log logger) Processor {
+	return func(ctx context.Context, headers map[string]string, body []byte) error {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+
+		for _, r := range rules {
+			if !r.match(headers) {
+				continue
+			}
+
+			data, err := r.Transcoder(headers, body)
+			if err != nil {
+				log.Errorf("Unable to transcode message body of type %q (%v): %v", headers["TYPE"], headers["CONTENT_TYPE"], err)
+				return ErrProcessingError
+			}
+
+			headers["ORIGINAL_CONTENT_TYPE"] = headers["CONTENT_TYPE"]
+			headers["CONTENT_TYPE"] = "application/json"
+
+			return p(ctx, headers, data)
+		}
+
+		return p(ctx, headers, body)
+	}
+}
+
+// NewMessagePackTranscoder creates transcoder which converts MessagePack into JSON
+func NewMessagePackTranscoder() Transcoder {
+	return func(headers map[string]string, body []byte) ([]byte, error) {
+		dec := msgpack.NewDecoder(bytes.NewReader(body))
+		dec.SetMapDecoder(func(d *msgpack.Decoder) (interface{}, error) {
+			return d.DecodeUntypedMap()
+		})
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(jsonCompatible(v))
+	}
+}
+
+// jsonCompatible converts maps with non-string keys, which are allowed in MessagePack, into maps with string keys
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(x)
+		}
+
+		return m
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = jsonCompatible(x)
+		}
+
+		return v
+	case []interface{}:
+		for i, x := range v {
+			v[i] = jsonCompatible(x)
+		}
+
+		return v
+	default:
+		return v
+	}
+}
+
+// NewProtobufTranscoder creates transcoder which converts Protobuf message into JSON. Message descriptors are loaded from
+// a file descriptor set (see protoc --descriptor_set_out), if message type is empty it's taken from message TYPE property.
+func NewProtobufTranscoder(descriptorSet string, messageType string) (Transcoder, error) {
+	data, err := ioutil.ReadFile(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("unable to parse descriptor set %v: %v", descriptorSet, err)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load descriptor set %v: %v", descriptorSet, err)
+	}
+
+	find := func(name string) (protoreflect.MessageDescriptor, error) {
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to find message type %q: %v", name, err)
+		}
+
+		md, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a message type", name)
+		}
+
+		return md, nil
+	}
+
+	var fixed protoreflect.MessageDescriptor
+	if messageType != "" {
+		if fixed, err = find(messageType); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(headers map[string]string, body []byte) ([]byte, error) {
+		md := fixed
+		if md == nil {
+			var err error
+			if md, err = find(headers["TYPE"]); err != nil {
+				return nil, err
+			}
+		}
+
+		msg := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(body, msg); err != nil {
+			return nil, err
+		}
+
+		return protojson.Marshal(msg)
+	}, nil
+}
+
+// NewAvroTranscoder creates transcoder which converts Avro binary encoded message into JSON using schema from a file
+func NewAvroTranscoder(schema string) (Transcoder, error) {
+	data, err := ioutil.ReadFile(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Avro schema %v: %v", schema, err)
+	}
+
+	return func(headers map[string]string, body []byte) ([]byte, error) {
+		v, _, err := codec.NativeFromBinary(body)
+		if err != nil {
+			return nil, err
+		}
+
+		return codec.TextualFromNative(nil, v)
+	}, nil
+}
@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"context"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"sync"
+	"testing"
+)
+
+func TestProcessorWithTranscoding(t *testing.T) {
+	body, _ := msgpack.Marshal(map[string]interface{}{"foo": "bar"})
+
+	rules := []TranscodingRule{
+		{ContentType: "application/msgpack", Transcoder: NewMessagePackTranscoder()},
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        string
+		err         error
+	}{
+		{name: "transcode", contentType: "application/msgpack", body: body, want: `{"foo":"bar"}`},
+		{name: "pass", contentType: "text/plain", body: []byte("foo"), want: "foo"},
+		{name: "invalid", contentType: "application/msgpack", body: []byte{0xc1}, err: ErrProcessingError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := func(c context.Context, h map[string]string, b []byte) error {
+				if string(b) != test.want {
+					t.Errorf("Body does not match: want %v, got %s", test.want, b)
+				}
+
+				return nil
+			}
+
+			err := ProcessorWithTranscoding(p, rules, &nilLogger{})(context.Background(), map[string]string{"CONTENT_TYPE": test.contentType}, test.body)
+			if err != test.err {
+				t.Errorf("Processor error does not match: want %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestNewMessagePackTranscoder_IntegerKeys(t *testing.T) {
+	body, _ := msgpack.Marshal(map[int]string{1: "foo"})
+
+	data, err := NewMessagePackTranscoder()(nil, body)
+	if err != nil {
+		t.Fatalf("Unable to transcode message: %v", err)
+	}
+
+	if string(data) != `{"1":"foo"}` {
+		t.Errorf("Transcoded message does not match: want %v, got %s", `{"1":"foo"}`, data)
+	}
+}
+
+func TestNewProtobufTranscoder(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("id"),
+				JsonName: proto.String("id"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+	}
+
+	set, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	filename := writeTempFile(t, "descriptors.pb", set)
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("Unable to build file descriptor: %v", err)
+	}
+
+	md := fd.Messages().ByName("Order")
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("id"), protoreflect.ValueOfString("42"))
+	body, _ := proto.Marshal(msg)
+
+	tr, err := NewProtobufTranscoder(filename, "")
+	if err != nil {
+		t.Fatalf("Unable to create transcoder: %v", err)
+	}
+
+	data, err := tr(map[string]string{"TYPE": "test.Order"}, body)
+	if err != nil {
+		t.Fatalf("Unable to transcode message: %v", err)
+	}
+
+	if string(data) != `{"id":"42"}` {
+		t.Errorf("Transcoded message does not match: want %v, got %s", `{"id":"42"}`, data)
+	}
+
+	if _, err := tr(map[string]string{"TYPE": "test.Unknown"}, body); err == nil {
+		t.Errorf("Unknown message type should cause an error")
+	}
+
+	// transcoder is shared by parallel workers, error of one message should not affect others
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(unknown bool) {
+			defer wg.Done()
+
+			typ := "test.Order"
+			if unknown {
+				typ = "test.Unknown"
+			}
+
+			if _, err := tr(map[string]string{"TYPE": typ}, body); (err != nil) != unknown {
+				t.Errorf("Unexpected transcoding result for message type %v: %v", typ, err)
+			}
+		}(i%2 == 1)
+	}
+
+	wg.Wait()
+}
+
+func TestNewAvroTranscoder(t *testing.T) {
+	filename := writeTempFile(t, "schema.avsc", []byte(`{"type":"record","name":"Order","fields":[{"name":"id","type":"long"}]}`))
+
+	tr, err := NewAvroTranscoder(filename)
+	if err != nil {
+		t.Fatalf("Unable to create transcoder: %v", err)
+	}
+
+	// long 42 is encoded as zig-zag varint 84
+	data, err := tr(nil, []byte{84})
+	if err != nil {
+		t.Fatalf("Unable to transcode message: %v", err)
+	}
+
+	if string(data) != `{"id":42}` {
+		t.Errorf("Transcoded message does not match: want %v, got %s", `{"id":42}`, data)
+	}
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"sync"
+)
+
+// publisher publishes messages using consumer channel in confirm mode, publishing waits while connection is blocked
+// or server has paused channel flow
+type publisher struct {
+	ch          *amqp.Channel
+	flow        *flow
+	channelFlow *flow
+	confirms    <-chan amqp.Confirmation
+	returns     <-chan amqp.Return
+	mu          sync.Mutex
+	seq         uint64
+}
+
+// newPublisher puts channel into confirm mode and starts routine which tracks channel flow
+func newPublisher(ch *amqp.Channel, fl *flow, log logger) (*publisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	p := &publisher{
+		ch:          ch,
+		flow:        fl,
+		channelFlow: &flow{},
+		confirms:    ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:     ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}
+
+	// flow notifications have to be read, otherwise channel stops, notification channel is closed with the channel
+	flows := ch.NotifyFlow(make(chan bool, 1))
+
+	go func() {
+		for active := range flows {
+			if active {
+				log.Infof("AMQP channel flow is resumed by server, publishing is resumed")
+				p.channelFlow.unblock()
+			} else {
+				log.Errorf("AMQP channel flow is paused by server, publishing is suspended")
+				p.channelFlow.block()
+			}
+		}
+	}()
+
+	return p, nil
+}
+
+// publish message to the queue and wait for confirmation, returns true if server has confirmed the message and routed
+// it to the queue. Messages are published one at a time, so returned message can be matched with its confirmation.
+func (p *publisher) publish(ctx context.Context, queue string, msg amqp.Publishing) (bool, error) {
+	if err := p.flow.wait(ctx); err != nil {
+		return false, err
+	}
+
+	if err := p.channelFlow.wait(ctx); err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// mandatory message which can not be routed to the queue (eq. queue does not exist) is returned before it's acked
+	if err := p.ch.Publish("", queue, true, false, msg); err != nil {
+		return false, err
+	}
+
+	p.seq++
+
+	return p.wait(ctx, p.seq)
+}
+
+// wait for confirmation of the message with given delivery tag
+func (p *publisher) wait(ctx context.Context, tag uint64) (bool, error) {
+	var returned bool
+
+	for {
+		select {
+		case _, ok := <-p.returns:
+			if !ok {
+				return false, nil
+			}
+
+			returned = true
+		case c, ok := <-p.confirms:
+			if !ok {
+				return false, nil
+			}
+
+			// return is sent before confirmation, so it's already buffered when confirmation arrives
+			select {
+			case <-p.returns:
+				returned = true
+			default:
+			}
+
+			// confirmation of a message which was not waited for, because publishing was interrupted, its return
+			// belongs to it as well
+			if c.DeliveryTag != tag {
+				returned = false
+				continue
+			}
+
+			return c.Ack && !returned, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
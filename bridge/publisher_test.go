@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestPublisher_Wait(t *testing.T) {
+	tests := []struct {
+		name          string
+		returns       int
+		confirmations []amqp.Confirmation
+		want          bool
+	}{
+		{name: "acked", confirmations: []amqp.Confirmation{{DeliveryTag: 2, Ack: true}}, want: true},
+		{name: "nacked", confirmations: []amqp.Confirmation{{DeliveryTag: 2, Ack: false}}, want: false},
+		{name: "returned", returns: 1, confirmations: []amqp.Confirmation{{DeliveryTag: 2, Ack: true}}, want: false},
+		{name: "previous message returned", returns: 1, confirmations: []amqp.Confirmation{{DeliveryTag: 1, Ack: true}, {DeliveryTag: 2, Ack: true}}, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			confirms := make(chan amqp.Confirmation, len(test.confirmations))
+			returns := make(chan amqp.Return, test.returns)
+
+			for i := 0; i < test.returns; i++ {
+				returns <- amqp.Return{ReplyCode: amqp.NoRoute}
+			}
+
+			for _, c := range test.confirmations {
+				confirms <- c
+			}
+
+			p := &publisher{confirms: confirms, returns: returns}
+
+			got, err := p.wait(context.Background(), 2)
+			if err != nil {
+				t.Fatalf("Unable to wait for confirmation: %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("Confirmation does not match: want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"golang.org/x/time/rate"
+	"sync"
+)
+
+// maximum number of idle per-key buckets to keep before cleaning them up
+const maxIdleRateBuckets = 1000
+
+// RateLimit limits number of messages processed per second using token bucket algorithm. If partition key is set,
+// every key gets its own bucket.
+type RateLimit struct {
+	rate    rate.Limit
+	burst   int
+	key     PartitionKey
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// NewRateLimit creates rate limit which allows perSecond messages per second with bursts of up to burst messages
+func NewRateLimit(perSecond float64, burst int, key PartitionKey) *RateLimit {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimit{
+		rate:    rate.Limit(perSecond),
+		burst:   burst,
+		key:     key,
+		buckets: make(map[string]*rate.Limiter),
+	}
+}
+
+// Wait until message can be processed
+func (r *RateLimit) Wait(ctx context.Context, d amqp.Delivery) error {
+	var key string
+	if r.key != nil {
+		key = r.key(d)
+	}
+
+	return r.bucket(key).Wait(ctx)
+}
+
+// bucket returns token bucket for given key, full buckets are removed when there are too many of them because they
+// are equivalent to new ones
+func (r *RateLimit) bucket(key string) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b, ok := r.buckets[key]; ok {
+		return b
+	}
+
+	if len(r.buckets) >= maxIdleRateBuckets {
+		for k, b := range r.buckets {
+			if b.Tokens() >= float64(r.burst) {
+				delete(r.buckets, k)
+			}
+		}
+	}
+
+	b := rate.NewLimiter(r.rate, r.burst)
+	r.buckets[key] = b
+
+	return b
+}
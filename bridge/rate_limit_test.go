@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	r := NewRateLimit(1, 2, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := r.Wait(ctx, amqp.Delivery{}); err != nil {
+			t.Fatalf("Messages within burst should not be throttled, got %v", err)
+		}
+	}
+
+	if err := r.Wait(ctx, amqp.Delivery{}); err == nil {
+		t.Errorf("Messages exceeding burst should be throttled")
+	}
+}
+
+func TestRateLimit_PartitionKey(t *testing.T) {
+	key, _ := NewPartitionKey("routing_key")
+	r := NewRateLimit(1, 1, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := r.Wait(ctx, amqp.Delivery{RoutingKey: "foo"}); err != nil {
+		t.Fatalf("First message should not be throttled, got %v", err)
+	}
+
+	if err := r.Wait(ctx, amqp.Delivery{RoutingKey: "bar"}); err != nil {
+		t.Errorf("Messages with different keys should not be throttled together, got %v", err)
+	}
+
+	if err := r.Wait(ctx, amqp.Delivery{RoutingKey: "foo"}); err == nil {
+		t.Errorf("Messages with the same key should be throttled")
+	}
+}
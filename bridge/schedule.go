@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// maximum number of overlapping schedule windows to check
+const maxScheduleWindows = 1000
+
+// ScheduleWindow is a time window which starts according to cron expression and lasts for given duration
+type ScheduleWindow struct {
+	Start    string
+	Duration time.Duration
+}
+
+type scheduleWindow struct {
+	start    cron.Schedule
+	duration time.Duration
+}
+
+// Schedule is a gate which is open only within schedule windows
+type Schedule struct {
+	windows []scheduleWindow
+	loc     *time.Location
+	now     func() time.Time
+}
+
+// NewSchedule creates schedule from a list of windows, cron expressions are evaluated in given time zone
+func NewSchedule(windows []ScheduleWindow, timezone string) (*Schedule, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schedule{loc: loc, now: time.Now}
+
+	for _, w := range windows {
+		start, err := cron.ParseStandard(w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse schedule window %q: %v", w.Start, err)
+		}
+
+		if w.Duration <= 0 {
+			return nil, fmt.Errorf("schedule window %q should have positive duration", w.Start)
+		}
+
+		s.windows = append(s.windows, scheduleWindow{start: start, duration: w.Duration})
+	}
+
+	return s, nil
+}
+
+// Open returns true if current time is within any of schedule windows
+func (s *Schedule) Open() (bool, string) {
+	now := s.now().In(s.loc)
+
+	var next time.Time
+
+	for _, w := range s.windows {
+		if end, ok := w.active(now); ok {
+			return true, ""
+		} else if next.IsZero() || end.Before(next) {
+			next = end
+		}
+	}
+
+	return false, fmt.Sprintf("outside of schedule, next window starts at %v", next.Format(time.RFC3339))
+}
+
+// active checks if window is active at given time. It returns time when window ends if it's active, or time when
+// it starts next time otherwise.
+func (w scheduleWindow) active(now time.Time) (time.Time, bool) {
+	start := w.start.Next(now.Add(-w.duration))
+	if start.After(now) {
+		return start, false
+	}
+
+	// find the latest window start which is not after now
+	for i := 0; i < maxScheduleWindows; i++ {
+		n := w.start.Next(start)
+		if n.After(now) {
+			break
+		}
+
+		start = n
+	}
+
+	return start.Add(w.duration), true
+}
@@ -0,0 +1,52 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := NewSchedule([]ScheduleWindow{{Start: "0 22 * * *", Duration: 8 * time.Hour}}, "Europe/Amsterdam")
+	if err != nil {
+		t.Fatalf("Unable to create schedule: %v", err)
+	}
+
+	loc, _ := time.LoadLocation("Europe/Amsterdam")
+
+	tests := []struct {
+		name string
+		now  time.Time
+		open bool
+	}{
+		{name: "before window", now: time.Date(2018, 1, 2, 21, 59, 0, 0, loc), open: false},
+		{name: "window start", now: time.Date(2018, 1, 2, 22, 0, 0, 0, loc), open: true},
+		{name: "after midnight", now: time.Date(2018, 1, 3, 5, 0, 0, 0, loc), open: true},
+		{name: "after window", now: time.Date(2018, 1, 3, 6, 0, 1, 0, loc), open: false},
+		{name: "other time zone", now: time.Date(2018, 1, 2, 21, 30, 0, 0, time.UTC), open: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.now = func() time.Time {
+				return test.now
+			}
+
+			if open, reason := s.Open(); open != test.open {
+				t.Errorf("Schedule state does not match: want %v, got %v (%v)", test.open, open, reason)
+			}
+		})
+	}
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	if _, err := NewSchedule([]ScheduleWindow{{Start: "foo", Duration: time.Hour}}, "UTC"); err == nil {
+		t.Errorf("Invalid cron expression should cause an error")
+	}
+
+	if _, err := NewSchedule([]ScheduleWindow{{Start: "0 22 * * *"}}, "UTC"); err == nil {
+		t.Errorf("Window without duration should cause an error")
+	}
+
+	if _, err := NewSchedule(nil, "Foo/Bar"); err == nil {
+		t.Errorf("Unknown time zone should cause an error")
+	}
+}
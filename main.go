@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"github.com/skolodyazhnyy/amqp-cgi-bridge/bridge"
 	"github.com/skolodyazhnyy/go-common/log"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
@@ -16,20 +19,147 @@ import (
 var version = "unknown"
 var commit = "unknown"
 
+var filename = flag.String("config", "config.yml", "Configuration")
+var drain = flag.Bool("once", false, "Stop once queues are drained, exit with non-zero code if any message failed")
+var maxMessages = flag.Int("max-messages", 0, "Stop consumer after given number of messages, implies -once")
+var idleTimeout = flag.Duration("idle-timeout", 5*time.Second, "Time to wait for new messages before queue is considered drained")
+
+type backOffConfig struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	ResetAfter time.Duration `yaml:"reset_after"`
+	Jitter     string
+}
+
+func (c backOffConfig) options() (bridge.BackOffOptions, error) {
+	jitter, err := bridge.ParseJitter(c.Jitter)
+	if err != nil {
+		return bridge.BackOffOptions{}, err
+	}
+
+	return bridge.BackOffOptions{
+		Initial:    c.Initial,
+		Multiplier: c.Multiplier,
+		Max:        c.Max,
+		ResetAfter: c.ResetAfter,
+		Jitter:     jitter,
+	}, nil
+}
+
 var config struct {
-	AMQPURL   string `yaml:"amqp_url"`
+	AMQPURL          string `yaml:"amqp_url"`
+	IdempotencyStore string `yaml:"idempotency_store"`
+	PauseFile        string `yaml:"pause_file"`
+	MetricsAddr      string `yaml:"metrics_addr"`
+	Limits           map[string]struct {
+		Concurrency int
+	}
 	Consumers []struct {
 		Queue          string
 		Prefetch       *int
 		Parallelism    int
 		FailureTimeout time.Duration
 		Env            map[string]string
-		FastCGI        struct {
+		BodyFormat     string `yaml:"body_format"`
+		ParkingQueue   string `yaml:"parking_queue"`
+		PartitionKey   string `yaml:"partition_key"`
+		Limit          string
+		Weight         int
+		PauseFile      string `yaml:"pause_file"`
+		Batch          *struct {
+			Size    int
+			Timeout time.Duration
+			Format  string
+		}
+		RateLimit struct {
+			Rate  float64
+			Burst int
+			Key   string
+		} `yaml:"rate_limit"`
+		Schedule struct {
+			Timezone string
+			Windows  []struct {
+				Start    string
+				Duration time.Duration
+			}
+		}
+		Adaptive struct {
+			Enabled   bool
+			Min       int
+			Max       int
+			Tolerance float64
+		}
+		Schema  string
+		Schemas []struct {
+			RoutingKey string `yaml:"routing_key"`
+			Schema     string
+		}
+		Form struct {
+			Field string
+			File  bool
+		}
+		Transcoding []struct {
+			ContentType   string `yaml:"content_type"`
+			Type          string
+			Format        string
+			DescriptorSet string `yaml:"descriptor_set"`
+			MessageType   string `yaml:"message_type"`
+			Schema        string
+		}
+		Deduplication struct {
+			Enabled bool
+			Key     string
+			TTL     time.Duration
+		}
+		Decryption struct {
+			KeyIDHeader   string `yaml:"key_id_header"`
+			DataKeyHeader string `yaml:"data_key_header"`
+			Keys          map[string]string
+		}
+		ClaimCheck struct {
+			Header  string
+			BaseDir string `yaml:"base_dir"`
+			Delete  bool
+			MaxSize int64 `yaml:"max_size"`
+		} `yaml:"claim_check"`
+		Signature struct {
+			Algorithm   string
+			Header      string
+			KeyIDHeader string `yaml:"key_id_header"`
+			Keys        map[string]string
+			Properties  []string
+		}
+		Decompression struct {
+			Enabled bool
+			MaxSize int64 `yaml:"max_size"`
+		}
+		Headers struct {
+			Prefix    *string
+			Normalize bool
+			Allow     []string
+			Deny      []string
+		}
+		FastCGI struct {
 			Net        string
 			Addr       string
 			ScriptName string `yaml:"script_name"`
+			Params     map[string]string
+			Autoscale  struct {
+				StatusPath string `yaml:"status_path"`
+				Interval   time.Duration
+				Min        int
+				Max        int
+			}
 		}
 	}
+	MaxReconnectAttempts int           `yaml:"max_reconnect_attempts"`
+	MaxDowntime          time.Duration `yaml:"max_downtime"`
+	BackOff              struct {
+		Reconnect backOffConfig
+		Restart   backOffConfig
+		Requeue   *backOffConfig
+	} `yaml:"backoff"`
 }
 
 func load(filename string, v interface{}) error {
@@ -43,9 +173,9 @@ func load(filename string, v interface{}) error {
 
 func main() {
 	// parse flags
-	filename := flag.String("config", "config.yml", "Configuration")
 	logfmt := flag.String("log", "text", "Log format: json or text")
 	printVersion := flag.Bool("v", false, "Print version")
+	flag.BoolVar(drain, "drain", false, "Alias for -once")
 	flag.Parse()
 
 	if *printVersion {
@@ -59,12 +189,79 @@ func main() {
 		"version": version,
 	})
 
-	if err := load(*filename, &config); err != nil {
+	// run returns once all deferred resources are released, so process can exit
+	if err := run(logger); err != nil {
 		logger.Fatal(err)
 	}
+}
+
+// run consumers until stopped by a signal or queues are drained
+func run(logger *log.Logger) error {
+	if err := load(*filename, &config); err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 	var queues []bridge.Queue
+	var store *bridge.IdempotencyStore
+	limits := make(map[string]*bridge.ConcurrencyLimit)
+	pollers := make(map[string]*bridge.FPMStatusPoller)
+
+	for name, l := range config.Limits {
+		if l.Concurrency <= 0 {
+			return fmt.Errorf("concurrency for limit %v should be a positive number", name)
+		}
+
+		limits[name] = bridge.NewConcurrencyLimit(l.Concurrency)
+	}
+
+	if config.MetricsAddr != "" {
+		l, err := net.Listen("tcp", config.MetricsAddr)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", expvar.Handler())
+
+		go func() {
+			if err := http.Serve(l, mux); err != nil {
+				logger.Errorf("Metrics server has stopped: %v", err)
+			}
+		}()
+	}
+
+	if config.IdempotencyStore != "" {
+		var err error
+
+		store, err = bridge.NewIdempotencyStore(config.IdempotencyStore, logger.Channel("idempotency"))
+		if err != nil {
+			return err
+		}
+
+		defer store.Close()
+	}
+
+	reconnect, err := config.BackOff.Reconnect.options()
+	if err != nil {
+		return err
+	}
+
+	restart, err := config.BackOff.Restart.options()
+	if err != nil {
+		return err
+	}
+
+	var requeue *bridge.BackOffOptions
+
+	if config.BackOff.Requeue != nil {
+		opts, err := config.BackOff.Requeue.options()
+		if err != nil {
+			return err
+		}
+
+		requeue = &opts
+	}
 
 	for _, c := range config.Consumers {
 		if c.FastCGI.Net == "" {
@@ -79,6 +276,10 @@ func main() {
 			c.FastCGI.ScriptName = "index.php"
 		}
 
+		if err := bridge.CheckFastCGI(c.FastCGI.Net, c.FastCGI.Addr, 5*time.Second); err != nil {
+			return fmt.Errorf("unable to start consumer for queue %v: %v", c.Queue, err)
+		}
+
 		p := bridge.NewFastCGIProcessor(
 			c.FastCGI.Net,
 			c.FastCGI.Addr,
@@ -88,14 +289,292 @@ func main() {
 			}),
 		)
 
+		if c.FastCGI.Params != nil {
+			var err error
+
+			p, err = bridge.ProcessorWithParams(p, c.FastCGI.Params, logger.Channel("fastcgi"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.Headers.Prefix == nil {
+			c.Headers.Prefix = &bridge.DefaultHeaderMapping.Prefix
+		}
+
+		mapping := &bridge.HeaderMapping{
+			Prefix:    *c.Headers.Prefix,
+			Normalize: c.Headers.Normalize,
+			Allow:     c.Headers.Allow,
+			Deny:      c.Headers.Deny,
+		}
+
+		if c.Form.Field == "" {
+			c.Form.Field = "body"
+		}
+
+		switch c.BodyFormat {
+		case "", "raw":
+		case "envelope":
+			p = bridge.ProcessorWithEnvelope(p, mapping)
+		case "form":
+			p = bridge.ProcessorWithForm(p, c.Form.Field, mapping)
+		case "multipart":
+			p = bridge.ProcessorWithMultipart(p, c.Form.Field, c.Form.File, mapping)
+		default:
+			return fmt.Errorf("unknown body format %q for queue %v", c.BodyFormat, c.Queue)
+		}
+
+		var schemas []bridge.SchemaRule
+
+		for _, s := range c.Schemas {
+			schemas = append(schemas, bridge.SchemaRule{RoutingKey: s.RoutingKey, Schema: s.Schema})
+		}
+
+		if c.Schema != "" {
+			schemas = append(schemas, bridge.SchemaRule{Schema: c.Schema})
+		}
+
+		if len(schemas) > 0 {
+			var err error
+
+			p, err = bridge.ProcessorWithSchema(p, schemas, logger.Channel("schema"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(c.Transcoding) > 0 {
+			var rules []bridge.TranscodingRule
+
+			for _, t := range c.Transcoding {
+				var tr bridge.Transcoder
+				var err error
+
+				switch t.Format {
+				case "msgpack":
+					tr = bridge.NewMessagePackTranscoder()
+				case "protobuf":
+					tr, err = bridge.NewProtobufTranscoder(t.DescriptorSet, t.MessageType)
+				case "avro":
+					tr, err = bridge.NewAvroTranscoder(t.Schema)
+				default:
+					err = fmt.Errorf("unknown transcoding format %q for queue %v", t.Format, c.Queue)
+				}
+
+				if err != nil {
+					return err
+				}
+
+				rules = append(rules, bridge.TranscodingRule{
+					ContentType: t.ContentType,
+					Type:        t.Type,
+					Transcoder:  tr,
+				})
+			}
+
+			p = bridge.ProcessorWithTranscoding(p, rules, logger.Channel("transcoding"))
+		}
+
+		if c.Decompression.Enabled {
+			if c.Decompression.MaxSize <= 0 {
+				c.Decompression.MaxSize = 64 << 20
+			}
+
+			p = bridge.ProcessorWithDecompression(p, c.Decompression.MaxSize, logger.Channel("decompression"))
+		}
+
+		if len(c.Decryption.Keys) > 0 {
+			if c.Decryption.KeyIDHeader == "" {
+				c.Decryption.KeyIDHeader = "x-encryption-key-id"
+			}
+
+			if c.Decryption.DataKeyHeader == "" {
+				c.Decryption.DataKeyHeader = "x-encrypted-key"
+			}
+
+			var err error
+
+			p, err = bridge.ProcessorWithDecryption(p, bridge.DecryptionOptions{
+				KeyIDHeader:   c.Decryption.KeyIDHeader,
+				DataKeyHeader: c.Decryption.DataKeyHeader,
+				Keys:          c.Decryption.Keys,
+			}, logger.Channel("decryption"))
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.ClaimCheck.BaseDir != "" {
+			if c.ClaimCheck.Header == "" {
+				c.ClaimCheck.Header = "x-claim-check"
+			}
+
+			var err error
+
+			p, err = bridge.ProcessorWithClaimCheck(p, bridge.ClaimCheckOptions{
+				Header:  c.ClaimCheck.Header,
+				BaseDir: c.ClaimCheck.BaseDir,
+				Delete:  c.ClaimCheck.Delete,
+				MaxSize: c.ClaimCheck.MaxSize,
+			}, logger.Channel("claim_check"))
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.Signature.Algorithm != "" {
+			if c.Signature.Header == "" {
+				c.Signature.Header = "x-signature"
+			}
+
+			if c.Signature.KeyIDHeader == "" {
+				c.Signature.KeyIDHeader = "x-key-id"
+			}
+
+			var err error
+
+			p, err = bridge.ProcessorWithSignature(p, bridge.SignatureOptions{
+				Algorithm:   c.Signature.Algorithm,
+				Header:      c.Signature.Header,
+				KeyIDHeader: c.Signature.KeyIDHeader,
+				Keys:        c.Signature.Keys,
+				Properties:  c.Signature.Properties,
+			}, logger.Channel("signature"))
+
+			if err != nil {
+				return err
+			}
+		}
+
 		if c.Env != nil {
 			p = bridge.ProcessorWithEnv(p, c.Env)
 		}
 
+		if c.Deduplication.Enabled {
+			if store == nil {
+				return fmt.Errorf("deduplication for queue %v requires idempotency store", c.Queue)
+			}
+
+			if c.Deduplication.TTL == 0 {
+				c.Deduplication.TTL = 24 * time.Hour
+			}
+
+			key, err := bridge.NewDeduplicationKey(c.Deduplication.Key)
+			if err != nil {
+				return err
+			}
+
+			p = bridge.ProcessorWithDeduplication(p, store, c.Queue, key, c.Deduplication.TTL, logger.Channel("deduplication"))
+		}
+
 		if c.Parallelism <= 0 {
 			c.Parallelism = 1
 		}
 
+		var adaptive *bridge.AdaptiveConcurrency
+
+		if c.Adaptive.Enabled {
+			if c.Adaptive.Max <= 0 {
+				c.Adaptive.Max = c.Parallelism
+			}
+
+			adaptive = bridge.NewAdaptiveConcurrency(c.Adaptive.Min, c.Adaptive.Max, c.Adaptive.Tolerance)
+
+			// parallelism and prefetch are upper bounds for adaptive concurrency limit
+			c.Parallelism = c.Adaptive.Max
+		}
+
+		if c.FastCGI.Autoscale.StatusPath != "" {
+			if adaptive != nil {
+				return fmt.Errorf("adaptive concurrency and PHP-FPM autoscaling can not be used together for queue %v", c.Queue)
+			}
+
+			if c.FastCGI.Autoscale.Max <= 0 {
+				c.FastCGI.Autoscale.Max = c.Parallelism
+			}
+
+			if c.FastCGI.Autoscale.Interval <= 0 {
+				c.FastCGI.Autoscale.Interval = 5 * time.Second
+			}
+
+			adaptive = bridge.NewScaledConcurrency(c.FastCGI.Autoscale.Min, c.FastCGI.Autoscale.Max)
+
+			// status page is polled once for all consumers using the same PHP-FPM pool
+			endpoint := c.FastCGI.Net + "://" + c.FastCGI.Addr + c.FastCGI.Autoscale.StatusPath
+
+			poller := pollers[endpoint]
+			if poller == nil {
+				poller = bridge.NewFPMStatusPoller(
+					c.FastCGI.Net,
+					c.FastCGI.Addr,
+					c.FastCGI.Autoscale.StatusPath,
+					c.FastCGI.Autoscale.Interval,
+					logger.Channel("fpm").With(log.R{
+						"addr": c.FastCGI.Addr,
+					}),
+				)
+
+				pollers[endpoint] = poller
+
+				defer poller.Stop()
+			}
+
+			if poller.Interval() != c.FastCGI.Autoscale.Interval {
+				return fmt.Errorf("autoscale interval for queue %v does not match other consumers using %v", c.Queue, endpoint)
+			}
+
+			bridge.NewFPMAutoscaler(poller, adaptive)
+
+			// parallelism and prefetch are upper bounds for scaled concurrency limit
+			c.Parallelism = c.FastCGI.Autoscale.Max
+		}
+
+		var batch *bridge.Batch
+
+		if c.Batch != nil {
+			if (c.BodyFormat != "" && c.BodyFormat != "raw") || c.Schema != "" || len(c.Schemas) > 0 || len(c.Transcoding) > 0 ||
+				c.Decompression.Enabled || len(c.Decryption.Keys) > 0 || c.ClaimCheck.BaseDir != "" ||
+				c.Signature.Algorithm != "" || c.Deduplication.Enabled || c.PartitionKey != "" {
+				return fmt.Errorf("batch mode for queue %v can not be combined with message transformations", c.Queue)
+			}
+
+			if c.Batch.Size <= 0 {
+				c.Batch.Size = 100
+			}
+
+			if c.Batch.Timeout <= 0 {
+				c.Batch.Timeout = time.Second
+			}
+
+			if c.Batch.Format != "" && c.Batch.Format != "json" && c.Batch.Format != "ndjson" {
+				return fmt.Errorf("unknown batch format %q for queue %v", c.Batch.Format, c.Queue)
+			}
+
+			batch = &bridge.Batch{
+				Size:    c.Batch.Size,
+				Timeout: c.Batch.Timeout,
+				Format:  c.Batch.Format,
+			}
+		}
+
+		if c.Prefetch == nil && batch != nil {
+			prefetch := c.Parallelism * batch.Size
+			c.Prefetch = &prefetch
+		}
+
+		// messages waiting for rate limit are held by consumer, so only as many as can be processed at once are prefetched
+		if c.Prefetch == nil && c.RateLimit.Rate > 0 && batch == nil {
+			prefetch := c.RateLimit.Burst
+			if prefetch < 1 {
+				prefetch = 1
+			}
+
+			c.Prefetch = &prefetch
+		}
+
 		if c.Prefetch == nil {
 			c.Prefetch = &c.Parallelism
 		}
@@ -104,22 +583,129 @@ func main() {
 			c.FailureTimeout = 10 * time.Second
 		}
 
+		// requeue delay is calculated for every message according to number of re-deliveries
+		var requeueBackOff *bridge.BackOff
+
+		if requeue != nil {
+			requeueBackOff = bridge.NewBackOff(*requeue)
+		}
+
+		var partitionKey bridge.PartitionKey
+
+		if c.PartitionKey != "" {
+			var err error
+
+			partitionKey, err = bridge.NewPartitionKey(c.PartitionKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		var rateLimit *bridge.RateLimit
+
+		if c.RateLimit.Rate > 0 {
+			var key bridge.PartitionKey
+
+			if c.RateLimit.Key != "" {
+				var err error
+
+				key, err = bridge.NewPartitionKey(c.RateLimit.Key)
+				if err != nil {
+					return err
+				}
+			}
+
+			rateLimit = bridge.NewRateLimit(c.RateLimit.Rate, c.RateLimit.Burst, key)
+		}
+
+		var gates []bridge.Gate
+
+		if config.PauseFile != "" {
+			gates = append(gates, bridge.PauseFile(config.PauseFile))
+		}
+
+		if c.PauseFile != "" {
+			gates = append(gates, bridge.PauseFile(c.PauseFile))
+		}
+
+		if len(c.Schedule.Windows) > 0 {
+			var windows []bridge.ScheduleWindow
+			for _, w := range c.Schedule.Windows {
+				windows = append(windows, bridge.ScheduleWindow{Start: w.Start, Duration: w.Duration})
+			}
+
+			schedule, err := bridge.NewSchedule(windows, c.Schedule.Timezone)
+			if err != nil {
+				return err
+			}
+
+			gates = append(gates, schedule)
+		}
+
+		var limit *bridge.ConcurrencyLimit
+
+		if c.Limit != "" {
+			if limit = limits[c.Limit]; limit == nil {
+				return fmt.Errorf("unknown concurrency limit %v for queue %v", c.Limit, c.Queue)
+			}
+		}
+
+		var drained *bridge.Drain
+
+		if *drain || *maxMessages > 0 {
+			drained = bridge.NewDrain(*idleTimeout, *maxMessages)
+		}
+
 		queues = append(queues, bridge.Queue{
 			Name:           c.Queue,
 			Prefetch:       *c.Prefetch,
 			Parallelism:    c.Parallelism,
 			FailureTimeout: c.FailureTimeout,
+			Requeue:        requeueBackOff,
+			ParkingQueue:   c.ParkingQueue,
+			PartitionKey:   partitionKey,
+			Batch:          batch,
+			Limit:          limit,
+			Adaptive:       adaptive,
+			RateLimit:      rateLimit,
+			Gates:          gates,
+			Drain:          drained,
+			Weight:         c.Weight,
+			Headers:        mapping,
 			Processor:      p,
 		})
 	}
 
-	cons := bridge.NewAMQPConsumer(ctx, config.AMQPURL, queues, logger.Channel("amqp"))
+	// fail fast on misconfiguration instead of re-connecting forever
+	if err := bridge.CheckQueues(config.AMQPURL, queues); err != nil {
+		return err
+	}
+
+	cons := bridge.NewAMQPConsumer(ctx, config.AMQPURL, queues, bridge.AMQPConsumerOptions{
+		MaxReconnectAttempts: config.MaxReconnectAttempts,
+		MaxDowntime:          config.MaxDowntime,
+		Reconnect:            reconnect,
+		Restart:              restart,
+	}, logger.Channel("amqp"))
 
 	signals := make(chan os.Signal)
 	signal.Notify(signals, os.Interrupt, os.Kill)
 
-	s := <-signals
-	logger.Infof("Signal %v received, stopping...", s)
+	select {
+	case s := <-signals:
+		logger.Infof("Signal %v received, stopping...", s)
+	case <-cons.Done():
+	}
 
 	cons.Stop()
+
+	if err := cons.Err(); err != nil {
+		return err
+	}
+
+	if n := cons.Failed(); n > 0 {
+		return fmt.Errorf("%v messages failed to process", n)
+	}
+
+	return nil
 }